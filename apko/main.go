@@ -3,7 +3,11 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"path"
+	"slices"
+	"sort"
 	"strings"
 
 	"dagger/apko/internal/dagger"
@@ -12,6 +16,16 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
+const (
+	apkoConfig    = "/apko/apko.yaml"
+	apkoConfigDir = "/apko-config"
+	apkoSbomDir   = "/apko/sbom"
+	apkoIndexSbom = "sbom-index.spdx.json"
+	apkoRootfs    = "rootfs.tar.gz"
+	cosignImage   = "ghcr.io/sigstore/cosign/cosign:v2.4.1"
+	cosignBin     = "/usr/local/bin/cosign"
+)
+
 // Apko Dagger Module
 type Apko struct{}
 
@@ -19,6 +33,22 @@ type Apko struct{}
 type ApkoConfig struct {
 	// +private
 	Cfg *dagger.File
+	// +private
+	Dir *dagger.Directory
+	// +private
+	Path string
+	// +private
+	RepositoryAuth *ApkoRepositoryAuth
+}
+
+// Credentials for authenticating with a private package repository using HTTP basic auth
+type ApkoRepositoryAuth struct {
+	// +private
+	Host string
+	// +private
+	Username string
+	// +private
+	Password *dagger.Secret
 }
 
 // Loads a pre-configured apko configuration file
@@ -30,6 +60,25 @@ func (a *Apko) Load(
 	return &ApkoConfig{Cfg: cfg}
 }
 
+// Loads a pre-configured apko configuration file from within a directory. The entire
+// directory is mounted, allowing apko to resolve any included files and relative references
+//
+// Examples:
+//
+// # Build an OCI image from an apko configuration file that includes a base configuration
+// $ dagger call load-dir --dir . --path variants/debug.yaml build --ref registry:5000/example:latest
+func (a *Apko) LoadDir(
+	// a path to a directory containing the apko configuration files
+	// +required
+	dir *dagger.Directory,
+	// the relative path of the apko configuration file within the directory
+	// +optional
+	// +default="apko.yaml"
+	path string,
+) *ApkoConfig {
+	return &ApkoConfig{Cfg: dir.File(path), Dir: dir, Path: path}
+}
+
 type imageConfig struct {
 	Archs        []string
 	Repositories []string
@@ -38,6 +87,8 @@ type imageConfig struct {
 	Entrypoint   string
 	Cmd          string
 	Env          []string
+	StopSignal   string
+	WorkDir      string
 }
 
 // Generates and loads a pre-configured apko configuration file for
@@ -51,6 +102,9 @@ type imageConfig struct {
 //
 // # Extend the default Wolfi OS apko configuration file
 // $ dagger call with-wolfi --entrypoint="echo \$VAR1" --env="VAR1:VALUE1"
+//
+// # Start the container within /app, stopping it gracefully with SIGQUIT
+// $ dagger call with-wolfi --entrypoint="/app/server" --work-dir=/app --stop-signal=SIGQUIT
 func (a *Apko) WithWolfi(
 	// a list of container architectures (defaults to amd64)
 	// +optional
@@ -67,6 +121,12 @@ func (a *Apko) WithWolfi(
 	// a list of packages to install within the container
 	// +optional
 	pkgs []string,
+	// the signal sent to the container to stop it, e.g. SIGQUIT
+	// +optional
+	stopSignal string,
+	// the working directory of the container, e.g. /app
+	// +optional
+	workDir string,
 ) (*ApkoConfig, error) {
 	packages := append([]string{
 		"wolfi-base",
@@ -81,6 +141,8 @@ func (a *Apko) WithWolfi(
 		Entrypoint:   entrypoint,
 		Cmd:          cmd,
 		Env:          env,
+		StopSignal:   stopSignal,
+		WorkDir:      workDir,
 	}
 
 	cfg, err := toFile(wolfi)
@@ -99,7 +161,7 @@ func toFile(cfg imageConfig) (*dagger.File, error) {
 		for _, e := range cfg.Env {
 			key, value, found := strings.Cut(e, ":")
 			if !found {
-				return nil, fmt.Errorf("failed to parse malformed environment variable argument", e)
+				return nil, fmt.Errorf("failed to parse malformed environment variable '%s', expected (key:value) format", e)
 			}
 			environment[strings.TrimSpace(key)] = strings.TrimSpace(value)
 		}
@@ -124,6 +186,8 @@ func toFile(cfg imageConfig) (*dagger.File, error) {
 			Command: cfg.Entrypoint,
 		},
 		Cmd:         cfg.Cmd,
+		StopSignal:  cfg.StopSignal,
+		WorkDir:     cfg.WorkDir,
 		Archs:       archs,
 		Environment: environment,
 	}
@@ -165,6 +229,12 @@ func (a *Apko) WithAlpine(
 	// a list of packages to install within the container
 	// +optional
 	pkgs []string,
+	// the signal sent to the container to stop it, e.g. SIGQUIT
+	// +optional
+	stopSignal string,
+	// the working directory of the container, e.g. /app
+	// +optional
+	workDir string,
 ) (*ApkoConfig, error) {
 	packages := append([]string{
 		"alpine-base",
@@ -178,6 +248,8 @@ func (a *Apko) WithAlpine(
 		Entrypoint:   entrypoint,
 		Cmd:          cmd,
 		Env:          env,
+		StopSignal:   stopSignal,
+		WorkDir:      workDir,
 	}
 
 	cfg, err := toFile(alpine)
@@ -188,6 +260,62 @@ func (a *Apko) WithAlpine(
 	return &ApkoConfig{Cfg: cfg}, nil
 }
 
+// Authenticates with a private package repository using HTTP basic auth, allowing packages
+// to be pulled from a token-protected package index. Credentials are exposed to apko through
+// the HTTP_AUTH environment variable, which is assembled from a secret at execution, and never
+// written to the configuration.
+// Only a single repository can be authenticated with, any subsequent call replaces it
+//
+// Examples:
+//
+// # Build an image using packages from a private repository
+// $ dagger call load --cfg apko.yaml with-repository-auth --host apk.example.com --username ci --password env:APK_TOKEN build --ref registry:5000/example:latest
+func (a *ApkoConfig) WithRepositoryAuth(
+	// the hostname of the package repository, e.g. apk.example.com
+	// +required
+	host string,
+	// the username for authenticating with the package repository
+	// +required
+	username string,
+	// the password or token for authenticating with the package repository
+	// +required
+	password *dagger.Secret,
+) *ApkoConfig {
+	a.RepositoryAuth = &ApkoRepositoryAuth{
+		Host:     host,
+		Username: username,
+		Password: password,
+	}
+	return a
+}
+
+// Mounts the apko configuration into the container, returning the path to the configuration file
+func (a *ApkoConfig) withConfig(ctr *dagger.Container) (*dagger.Container, string) {
+	if a.RepositoryAuth != nil {
+		ctr = ctr.WithEnvVariable("APKO_AUTH_HOST", a.RepositoryAuth.Host).
+			WithEnvVariable("APKO_AUTH_USER", a.RepositoryAuth.Username).
+			WithSecretVariable("APKO_AUTH_PASSWORD", a.RepositoryAuth.Password)
+	}
+
+	if a.Dir == nil {
+		return ctr.WithFile("apko.yaml", a.Cfg), apkoConfig
+	}
+
+	return ctr.WithMountedDirectory(apkoConfigDir, a.Dir), path.Join(apkoConfigDir, a.Path)
+}
+
+// Wraps an apko command, exposing any repository credentials through the HTTP_AUTH
+// environment variable. It is assembled within the shell, so the password is never
+// unwrapped from its secret
+func (a *ApkoConfig) withAuth(cmd []string) []string {
+	if a.RepositoryAuth == nil {
+		return cmd
+	}
+
+	script := `HTTP_AUTH="basic:${APKO_AUTH_HOST}:${APKO_AUTH_USER}:${APKO_AUTH_PASSWORD}" exec "$@"`
+	return append([]string{"sh", "-c", script, "sh"}, cmd...)
+}
+
 // Prints the generated apko configuration file to stdout
 func (a *ApkoConfig) Yaml(ctx context.Context) (string, error) {
 	return a.Cfg.Contents(ctx)
@@ -206,9 +334,14 @@ func (a *ApkoConfig) Yaml(ctx context.Context) (string, error) {
 // # Build an OCI image based on the Wolfi OS
 // $ dagger call with-wolfi build --ref registry:5000/example:latest
 func (a *ApkoConfig) Build(
+	ctx context.Context,
 	// additional OCI annotations to add to the built image, expected in (key:value) format
 	// +optional
 	annotations []string,
+	// a file of additional OCI annotations to add to the built image, expected in (key=value)
+	// format, one per line. Inline annotations take precedence over those within the file
+	// +optional
+	annotationsFile *dagger.File,
 	// a list of architectures to build, overwriting the config
 	// +optional
 	archs []string,
@@ -229,20 +362,109 @@ func (a *ApkoConfig) Build(
 	// +optional
 	// +default=true
 	sbom bool,
-) *dagger.Directory {
+	// a list of formats for the generated SBOM (spdx,cyclonedx). Ignored if SBOM
+	// generation is disabled
+	// +optional
+	sbomFormats []string,
+) (*dagger.Directory, error) {
+	annotations, err := mergeAnnotations(ctx, annotations, annotationsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	ctr, cfg := a.withConfig(base())
+
 	cmd := []string{
 		"apko",
 		"build",
-		"/apko/apko.yaml",
+		cfg,
 		ref,
 		imageFromRef(ref),
 	}
-	cmd = append(cmd, formatArgs(annotations, archs, pkgs, repos, ref, vcs, sbom)...)
+	cmd = append(cmd, formatArgs(annotations, archs, pkgs, repos, ref, vcs, sbom, sbomFormats)...)
+
+	return ctr.
+		WithExec(a.withAuth(cmd)).
+		Directory(""), nil
+}
+
+// Builds a plain root filesystem from an apko configuration file, rather than an OCI image,
+// and outputs it as a gzipped tarball. No image reference is needed. Only a single architecture
+// is built, any architectures within the config are ignored. Useful as a base layer elsewhere,
+// such as within a multi-stage docker build:
+//
+//	FROM scratch
+//	ADD rootfs.tar.gz /
+//
+// Examples:
+//
+// # Build a root filesystem based on the Wolfi OS
+// $ dagger call with-wolfi rootfs export --path rootfs.tar.gz
+//
+// # Build an arm64 root filesystem from a provided apko configuration file
+// $ dagger call load --cfg apko.yaml rootfs --arch arm64 export --path rootfs.tar.gz
+func (a *ApkoConfig) Rootfs(
+	// the architecture of the root filesystem, defaults to the architecture of the host
+	// +optional
+	arch string,
+) *dagger.File {
+	ctr, cfg := a.withConfig(base())
+
+	cmd := []string{"apko", "build-minirootfs", cfg, apkoRootfs}
+	if arch != "" {
+		cmd = append(cmd, "--build-arch", arch)
+	}
 
-	return base().
-		WithFile("apko.yaml", a.Cfg).
-		WithExec(cmd).
-		Directory("")
+	return ctr.
+		WithExec(a.withAuth(cmd)).
+		File(apkoRootfs)
+}
+
+// Merges annotations loaded from a file, in (key=value) format, with those provided inline,
+// in (key:value) format. Inline annotations take precedence
+func mergeAnnotations(ctx context.Context, annotations []string, file *dagger.File) ([]string, error) {
+	if file == nil {
+		return annotations, nil
+	}
+
+	contents, err := file.Contents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := map[string]string{}
+	for _, line := range strings.Split(contents, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("failed to parse malformed annotation '%s' within file, expected (key=value) format", line)
+		}
+		merged[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+
+	for _, annotation := range annotations {
+		key, value, found := strings.Cut(annotation, ":")
+		if !found {
+			return nil, fmt.Errorf("failed to parse malformed annotation '%s', expected (key:value) format", annotation)
+		}
+		merged[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+
+	keys := make([]string, 0, len(merged))
+	for key := range merged {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var result []string
+	for _, key := range keys {
+		result = append(result, key+":"+merged[key])
+	}
+	return result, nil
 }
 
 func imageFromRef(ref string) string {
@@ -257,7 +479,7 @@ func imageFromRef(ref string) string {
 	return image + ".tar"
 }
 
-func formatArgs(annotations, archs, pkgs, repos []string, ref string, vcs, sbom bool) []string {
+func formatArgs(annotations, archs, pkgs, repos []string, ref string, vcs, sbom bool, sbomFormats []string) []string {
 	var args []string
 
 	if len(archs) > 0 {
@@ -278,6 +500,8 @@ func formatArgs(annotations, archs, pkgs, repos []string, ref string, vcs, sbom
 
 	if !sbom {
 		args = append(args, "--sbom=false")
+	} else if len(sbomFormats) > 0 {
+		args = append(args, "--sbom-formats", strings.Join(sbomFormats, ","))
 	}
 
 	if !vcs {
@@ -309,6 +533,10 @@ func (a *ApkoConfig) Publish(
 	// additional OCI annotations to add to the built image, expected in (key:value) format
 	// +optional
 	annotations []string,
+	// a file of additional OCI annotations to add to the built image, expected in (key=value)
+	// format, one per line. Inline annotations take precedence over those within the file
+	// +optional
+	annotationsFile *dagger.File,
 	// a list of architectures to build, overwriting the config
 	// +optional
 	archs []string,
@@ -329,6 +557,10 @@ func (a *ApkoConfig) Publish(
 	// +optional
 	// +default=true
 	sbom bool,
+	// a list of formats for the generated SBOM (spdx,cyclonedx). Ignored if SBOM
+	// generation is disabled
+	// +optional
+	sbomFormats []string,
 	// the address of the registry to authenticate with
 	// +optional
 	// +default="docker.io"
@@ -339,15 +571,90 @@ func (a *ApkoConfig) Publish(
 	// the password for authenticating with the registry
 	// +optional
 	password *dagger.Secret,
+	// a cosign private key for signing the published image. If an SPDX SBOM is generated,
+	// the image is also attested with it
+	// +optional
+	cosignKey *dagger.Secret,
+	// the password for decrypting the cosign private key
+	// +optional
+	cosignPassword *dagger.Secret,
+	// an OIDC identity token for keyless signing (and attesting) of the published image
+	// with cosign. Ignored if a cosign private key is provided
+	// +optional
+	cosignIdentityToken *dagger.Secret,
 ) (string, error) {
-	cmd := []string{
-		"apko",
-		"publish",
-		"/apko/apko.yaml",
-		ref,
+	annotations, err := mergeAnnotations(ctx, annotations, annotationsFile)
+	if err != nil {
+		return "", err
 	}
-	cmd = append(cmd, formatArgs(annotations, archs, pkgs, repos, ref, vcs, sbom)...)
 
+	signing := cosignKey != nil || cosignIdentityToken != nil
+	attest := signing && sbom && (len(sbomFormats) == 0 || slices.Contains(sbomFormats, "spdx"))
+
+	args := []string{ref}
+	if attest {
+		args = append(args, "--sbom-path", apkoSbomDir)
+	}
+	args = append(args, formatArgs(annotations, archs, pkgs, repos, ref, vcs, sbom, sbomFormats)...)
+
+	ctr := a.publish(args, registry, username, password)
+
+	out, err := ctr.Stdout(ctx)
+	if err != nil || !signing {
+		return out, err
+	}
+
+	digestRef, err := publishedDigestRef(out)
+	if err != nil {
+		return "", err
+	}
+
+	// cosign is copied from a pinned release, reusing the registry credentials written by apko login
+	ctr = ctr.WithFile(cosignBin, dag.Container().From(cosignImage).File("/ko-app/cosign"))
+
+	var keyArgs []string
+	if cosignKey != nil {
+		ctr = ctr.WithSecretVariable("COSIGN_KEY", cosignKey)
+		keyArgs = []string{"--key", "env://COSIGN_KEY"}
+
+		if cosignPassword != nil {
+			ctr = ctr.WithSecretVariable("COSIGN_PASSWORD", cosignPassword)
+		}
+	} else {
+		ctr = ctr.WithSecretVariable("SIGSTORE_ID_TOKEN", cosignIdentityToken)
+	}
+
+	repo, digest, _ := strings.Cut(digestRef, "@")
+	sigRef := fmt.Sprintf("%s:%s", repo, strings.Replace(digest, ":", "-", 1))
+
+	ctr = ctr.WithExec(append(append([]string{"cosign", "sign", "--yes"}, keyArgs...), digestRef))
+	out += fmt.Sprintf("Signed: %s.sig\n", sigRef)
+
+	if attest {
+		cmd := []string{"cosign", "attest", "--yes", "--type", "spdxjson", "--predicate", path.Join(apkoSbomDir, apkoIndexSbom)}
+		ctr = ctr.WithExec(append(append(cmd, keyArgs...), digestRef))
+		out += fmt.Sprintf("Attested: %s.att\n", sigRef)
+	}
+
+	if _, err := ctr.Sync(ctx); err != nil {
+		return "", err
+	}
+
+	return out, nil
+}
+
+// The digest of the published image index is printed on the last line
+func publishedDigestRef(out string) (string, error) {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	digestRef := strings.TrimSpace(lines[len(lines)-1])
+
+	if _, digest, found := strings.Cut(digestRef, "@"); !found || !strings.Contains(digest, ":") {
+		return "", fmt.Errorf("failed to extract digest from published image reference '%s'", digestRef)
+	}
+	return digestRef, nil
+}
+
+func (a *ApkoConfig) publish(args []string, registry, username string, password *dagger.Secret) *dagger.Container {
 	ctr := base()
 
 	if registry != "" && username != "" && password != nil {
@@ -357,8 +664,119 @@ func (a *ApkoConfig) Publish(
 			WithExec([]string{"sh", "-c", "apko login $REGISTRY -u $REGISTRY_USER -p $REGISTRY_PASSWORD"})
 	}
 
-	return ctr.
-		WithFile("apko.yaml", a.Cfg).
-		WithExec(cmd).
-		Stdout(ctx)
+	ctr, cfg := a.withConfig(ctr)
+
+	cmd := append([]string{"apko", "publish", cfg}, args...)
+	return ctr.WithExec(a.withAuth(cmd))
+}
+
+// An in-toto statement linking a published image digest to its SBOM,
+// https://github.com/in-toto/attestation/blob/main/spec/v1/statement.md
+type inTotoStatement struct {
+	Type          string          `json:"_type"`
+	Subject       []inTotoSubject `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     json.RawMessage `json:"predicate"`
+}
+
+type inTotoSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// Builds an image from an apko configuration file, publishes it to an OCI image registry
+// and generates an in-toto attestation that links the digest of the published image to
+// its SBOM (software bill of materials). A directory is returned containing the attestation
+// (attestation.intoto.json), the digest of the published image (digest.txt) and all
+// generated SBOMs, ready to be attested by a tool such as cosign
+//
+// Examples:
+//
+// # Publish an OCI image based on the Wolfi OS with an attestation
+// $ dagger call with-wolfi publish-with-attestation --ref registry:5000/example:latest export --path attestation
+func (a *ApkoConfig) PublishWithAttestation(
+	ctx context.Context,
+	// additional OCI annotations to add to the built image, expected in (key:value) format
+	// +optional
+	annotations []string,
+	// a file of additional OCI annotations to add to the built image, expected in (key=value)
+	// format, one per line. Inline annotations take precedence over those within the file
+	// +optional
+	annotationsFile *dagger.File,
+	// a list of architectures to build, overwriting the config
+	// +optional
+	archs []string,
+	// a list of additional packages to include within the built image
+	// +optional
+	pkgs []string,
+	// a list of additional repositories used to pull packages into the built image
+	// +optional
+	repos []string,
+	// the image reference to build
+	// +required
+	ref string,
+	// detect and embed VCS URLs within the built OCI image
+	// +optional
+	// +default=true
+	vcs bool,
+	// the address of the registry to authenticate with
+	// +optional
+	// +default="docker.io"
+	registry,
+	// the username for authenticating with the registry
+	// +optional
+	username string,
+	// the password for authenticating with the registry
+	// +optional
+	password *dagger.Secret,
+) (*dagger.Directory, error) {
+	annotations, err := mergeAnnotations(ctx, annotations, annotationsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{ref, "--sbom-path", apkoSbomDir}
+	args = append(args, formatArgs(annotations, archs, pkgs, repos, ref, vcs, true, nil)...)
+
+	ctr := a.publish(args, registry, username, password)
+
+	out, err := ctr.Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	digestRef, err := publishedDigestRef(out)
+	if err != nil {
+		return nil, err
+	}
+
+	name, digest, _ := strings.Cut(digestRef, "@")
+	algorithm, hash, _ := strings.Cut(digest, ":")
+
+	sboms := ctr.Directory(apkoSbomDir)
+	sbom, err := sboms.File(apkoIndexSbom).Contents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statement := inTotoStatement{
+		Type: "https://in-toto.io/Statement/v1",
+		Subject: []inTotoSubject{
+			{
+				Name:   name,
+				Digest: map[string]string{algorithm: hash},
+			},
+		},
+		PredicateType: "https://spdx.dev/Document",
+		Predicate:     json.RawMessage(sbom),
+	}
+
+	attestation, err := json.MarshalIndent(statement, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return sboms.
+		WithNewFile("attestation.intoto.json", string(attestation), dagger.DirectoryWithNewFileOpts{Permissions: 0o644}).
+		WithNewFile("digest.txt", digestRef, dagger.DirectoryWithNewFileOpts{Permissions: 0o644}), nil
 }
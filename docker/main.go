@@ -4,13 +4,43 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"encoding/json"
 	"fmt"
+	"path"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"dagger/docker/internal/dagger"
 )
 
+const (
+	// An environment variable used for busting the dagger cache
+	noCacheArg = "DAGGER_NO_CACHE"
+
+	// The path of a forwarded SSH agent socket when building with BuildKit
+	sshAgentSocket = "/tmp/ssh-agent.sock"
+
+	BuildkitImage      = "moby/buildkit:v0.16.0"
+	BusyboxImage       = "busybox:1.36.1"
+	DockerCliImage     = "docker:27-cli"
+	DockerSocket       = "/var/run/docker.sock"
+	OrasImage          = "ghcr.io/oras-project/oras:v1.2.0"
+	OrasRegistryConfig = "/tmp/oras/config.json"
+	SyftImage          = "anchore/syft:v1.14.0"
+
+	inTotoMediaType     = "application/vnd.in-toto+json"
+	spdxMediaType       = "application/spdx+json"
+	platformAnnotation  = "dev.dagger.image.platform"
+	slsaProvenanceType  = "https://slsa.dev/provenance/v1"
+	dockerBuildType     = "https://github.com/purpleclay/daggerverse/docker/build@v1"
+	dockerBuilderID     = "https://github.com/purpleclay/daggerverse/docker"
+	inTotoStatementType = "https://in-toto.io/Statement/v1"
+)
+
 // Docker dagger module
 type Docker struct {
 	// +private
@@ -69,10 +99,17 @@ type DockerBuild struct {
 	// +private
 	// +optional
 	Auth *DockerAuth
+	// +private
+	// +optional
+	Dockerfile string
+	// +private
+	// +optional
+	Target string
 }
 
 // Build an image using a Dockerfile. Supports multi-platform images
 func (d *Docker) Build(
+	ctx context.Context,
 	// the path to a directory that will be used as the docker context
 	// +required
 	dir *dagger.Directory,
@@ -90,7 +127,41 @@ func (d *Docker) Build(
 	// +optional
 	// +default=["linux/amd64"]
 	platform []dagger.Platform,
-) *DockerBuild {
+	// disable the build cache, forcing every instruction within the Dockerfile to be
+	// executed. The dagger engine cannot disable its build cache, so the image is instead
+	// built by BuildKit directly, within a privileged container
+	// +optional
+	noCache bool,
+	// always attempt to pull a newer version of any base image referenced by a FROM
+	// instruction, rather than using a cached version. The image is built by BuildKit
+	// directly, within a privileged container
+	// +optional
+	pull bool,
+	// a private SSH key for accessing private git repositories during the build. The key is
+	// exposed as a build secret, ensuring it never persists within an image layer, with an ID
+	// matching the name of the secret. It can be mounted within the Dockerfile using:
+	// RUN --mount=type=secret,id=<name>,target=/root/.ssh/id_ed25519
+	// +optional
+	sshKey *dagger.Secret,
+	// an SSH agent socket to forward during the build, for accessing private git repositories,
+	// e.g. $SSH_AUTH_SOCK. Forwarding of an SSH agent is not supported by the dagger engine,
+	// so the image is built by BuildKit directly, within a privileged container. It can be
+	// mounted within the Dockerfile using: RUN --mount=type=ssh
+	// +optional
+	sshAgent *dagger.Socket,
+	// flatten the built image into a single layer, reducing its size and pull time. A squashed
+	// image cannot share layers with other images, so every change results in the entire image
+	// being pushed and pulled again. Any HEALTHCHECK instruction is not retained
+	// +optional
+	squash bool,
+	// a custom Dockerfile frontend image for building the image, e.g. docker/dockerfile:1.7.
+	// The dagger engine builds images using its own built-in frontend, ignoring any # syntax=
+	// directive within the Dockerfile. If provided, the image is instead built by BuildKit
+	// directly, within a privileged container, using the requested frontend. Builds will be
+	// slower, as they cannot share the cache of the dagger engine
+	// +optional
+	syntax string,
+) (*DockerBuild, error) {
 	var buildArgs []dagger.BuildArg
 	if len(args) > 0 {
 		for _, arg := range args {
@@ -103,6 +174,17 @@ func (d *Docker) Build(
 		}
 	}
 
+	var secrets []*dagger.Secret
+	var sshKeyName string
+	if sshKey != nil {
+		// Secrets are mounted using their name, which is also used as the ID with BuildKit
+		var err error
+		if sshKeyName, err = sshKey.Name(ctx); err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, sshKey)
+	}
+
 	var builds []*dagger.Container
 	for _, pform := range platform {
 		ctr := dag.Container(dagger.ContainerOpts{Platform: pform})
@@ -110,16 +192,176 @@ func (d *Docker) Build(
 			ctr = ctr.WithRegistryAuth(d.Auth.Registry, d.Auth.Username, d.Auth.Password)
 		}
 
-		ctr = ctr.Build(dir, dagger.ContainerBuildOpts{
-			BuildArgs:  buildArgs,
-			Dockerfile: file,
-			Target:     target,
-		})
+		if syntax != "" || noCache || pull || sshAgent != nil {
+			var err error
+			ctr, err = buildWithFrontend(ctx, frontendOpts{
+				Auth:       d.Auth,
+				BuildArgs:  buildArgs,
+				Dir:        dir,
+				File:       file,
+				NoCache:    noCache,
+				Platform:   pform,
+				Pull:       pull,
+				SshAgent:   sshAgent,
+				SshKey:     sshKey,
+				SshKeyName: sshKeyName,
+				Syntax:     syntax,
+				Target:     target,
+			})
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			ctr = ctr.Build(dir, dagger.ContainerBuildOpts{
+				BuildArgs:  buildArgs,
+				Dockerfile: file,
+				Target:     target,
+				Secrets:    secrets,
+			})
+		}
+
+		if squash {
+			var err error
+			if ctr, err = squashLayers(ctx, ctr, pform); err != nil {
+				return nil, err
+			}
+		}
 
 		builds = append(builds, ctr)
 	}
 
-	return &DockerBuild{Builds: builds, Auth: d.Auth}
+	return &DockerBuild{
+		Builds:     builds,
+		Auth:       d.Auth,
+		Dockerfile: file,
+		Target:     target,
+	}, nil
+}
+
+type frontendOpts struct {
+	Auth       *DockerAuth
+	BuildArgs  []dagger.BuildArg
+	Dir        *dagger.Directory
+	File       string
+	NoCache    bool
+	Platform   dagger.Platform
+	Pull       bool
+	SshAgent   *dagger.Socket
+	SshKey     *dagger.Secret
+	SshKeyName string
+	Syntax     string
+	Target     string
+}
+
+// Builds an image using BuildKit directly, with either a custom or the built-in Dockerfile
+// frontend. BuildKit is run without a daemon, and the built image is imported back into
+// dagger as a container
+func buildWithFrontend(ctx context.Context, opts frontendOpts) (*dagger.Container, error) {
+	cmd := []string{"buildctl-daemonless.sh", "build"}
+	if opts.Syntax != "" {
+		cmd = append(cmd, "--frontend", "gateway.v0", "--opt", "source="+opts.Syntax)
+	} else {
+		cmd = append(cmd, "--frontend", "dockerfile.v0")
+	}
+
+	cmd = append(cmd,
+		"--opt", "filename="+path.Base(opts.File),
+		"--opt", "platform="+string(opts.Platform),
+		"--local", "context=/work",
+		"--local", "dockerfile="+path.Join("/work", path.Dir(opts.File)),
+		"--output", "type=oci,dest=/tmp/image.tar",
+	)
+
+	if opts.NoCache {
+		cmd = append(cmd, "--no-cache")
+	}
+
+	if opts.Pull {
+		cmd = append(cmd, "--opt", "image-resolve-mode=pull")
+	}
+
+	if opts.Target != "" {
+		cmd = append(cmd, "--opt", "target="+opts.Target)
+	}
+
+	for _, arg := range opts.BuildArgs {
+		cmd = append(cmd, "--opt", fmt.Sprintf("build-arg:%s=%s", arg.Name, arg.Value))
+	}
+
+	ctr := dag.Container().
+		From(BuildkitImage).
+		WithEnvVariable("BUILDKITD_FLAGS", "--oci-worker-no-process-sandbox").
+		WithMountedDirectory("/work", opts.Dir)
+
+	// The build itself must never be cached by dagger, when BuildKit is forced to run every step
+	if opts.NoCache || opts.Pull {
+		ctr = ctr.WithEnvVariable(noCacheArg, strconv.FormatInt(time.Now().UnixNano(), 10))
+	}
+
+	if opts.SshKey != nil {
+		ctr = ctr.WithSecretVariable("SSH_KEY", opts.SshKey)
+		cmd = append(cmd, "--secret", fmt.Sprintf("id=%s,env=SSH_KEY", opts.SshKeyName))
+	}
+
+	if opts.SshAgent != nil {
+		ctr = ctr.WithUnixSocket(sshAgentSocket, opts.SshAgent)
+		cmd = append(cmd, "--ssh", "default="+sshAgentSocket)
+	}
+
+	if opts.Auth != nil {
+		ctr = ctr.WithMountedSecret("/root/.docker/config.json", registryConfig(opts.Auth))
+	}
+
+	image := ctr.
+		WithExec(cmd, dagger.ContainerWithExecOpts{InsecureRootCapabilities: true}).
+		File("/tmp/image.tar")
+
+	return dag.Container(dagger.ContainerOpts{Platform: opts.Platform}).Import(image), nil
+}
+
+// Squashes a built image into a single layer by copying its root filesystem into an empty
+// directory and re-applying its config to a new container
+func squashLayers(ctx context.Context, build *dagger.Container, platform dagger.Platform) (*dagger.Container, error) {
+	cfg, err := inspect(ctx, build)
+	if err != nil {
+		return nil, err
+	}
+
+	ctr := dag.Container(dagger.ContainerOpts{Platform: platform}).
+		WithRootfs(dag.Directory().WithDirectory("/", build.Rootfs())).
+		WithEntrypoint(cfg.Entrypoint).
+		WithDefaultArgs(cfg.Cmd)
+
+	if cfg.User != "" {
+		ctr = ctr.WithUser(cfg.User)
+	}
+
+	if cfg.WorkingDir != "" {
+		ctr = ctr.WithWorkdir(cfg.WorkingDir)
+	}
+
+	for _, env := range cfg.Env {
+		name, value, _ := strings.Cut(env, "=")
+		ctr = ctr.WithEnvVariable(name, value)
+	}
+
+	for port := range cfg.ExposedPorts {
+		number, protocol, _ := strings.Cut(port, "/")
+		p, err := strconv.Atoi(number)
+		if err != nil {
+			return nil, err
+		}
+
+		ctr = ctr.WithExposedPort(p, dagger.ContainerWithExposedPortOpts{
+			Protocol: dagger.NetworkProtocol(strings.ToUpper(protocol)),
+		})
+	}
+
+	for name, value := range cfg.Labels {
+		ctr = ctr.WithLabel(name, value)
+	}
+
+	return ctr, nil
 }
 
 // Save the built image as a tarball ready for exporting. A tarball will be generated using
@@ -148,6 +390,66 @@ func (d *DockerBuild) Save(
 	return dir
 }
 
+// Loads a built image for a given platform into a docker daemon on the host, tagging it with
+// the provided reference. The docker socket of the host must be provided
+//
+// Examples:
+//
+// # Load the built image into the local docker daemon and run it
+// $ dagger call build --dir . load --socket /var/run/docker.sock --ref example:dev
+// $ docker run --rm example:dev
+func (d *DockerBuild) Load(
+	ctx context.Context,
+	// the socket of the host docker daemon, typically /var/run/docker.sock
+	// +required
+	socket *dagger.Socket,
+	// the reference to tag the loaded image with
+	// +optional
+	// +default="dagger-build:latest"
+	ref string,
+	// the platform of the docker image to load
+	// +optional
+	// +default="linux/amd64"
+	platform dagger.Platform,
+) (string, error) {
+	build, err := d.Image(ctx, platform)
+	if err != nil {
+		return "", err
+	}
+
+	ctr := dag.Container().
+		From(DockerCliImage).
+		WithUnixSocket(DockerSocket, socket).
+		WithMountedFile("/tmp/image.tar", build.AsTarball()).
+		// Loading modifies the state of the host, so must never be cached
+		WithEnvVariable(noCacheArg, strconv.FormatInt(time.Now().UnixNano(), 10))
+
+	out, err := ctr.WithExec([]string{"docker", "load", "--input", "/tmp/image.tar"}).Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	// An OCI tarball has no image name, so it is loaded using its ID
+	var loaded string
+	for _, line := range strings.Split(out, "\n") {
+		if id, found := strings.CutPrefix(line, "Loaded image ID:"); found {
+			loaded = strings.TrimSpace(id)
+		} else if name, found := strings.CutPrefix(line, "Loaded image:"); found {
+			loaded = strings.TrimSpace(name)
+		}
+	}
+
+	if loaded == "" {
+		return "", fmt.Errorf("failed to identify the loaded image from output:\n%s", out)
+	}
+
+	if _, err := ctr.WithExec([]string{"docker", "tag", loaded, ref}).Sync(ctx); err != nil {
+		return "", err
+	}
+
+	return ref, nil
+}
+
 // Retrieves a built image for a given platform as a container
 func (d *DockerBuild) Image(
 	ctx context.Context,
@@ -171,7 +473,468 @@ func (d *DockerBuild) Image(
 	return nil, fmt.Errorf("no built image exists for platform '%s'", platform)
 }
 
+// Extracts a directory from a built image for a given platform. When combined with a target
+// build stage, files can be retrieved from an intermediate stage of a multi-stage build,
+// without them being shipped within the final image
+//
+// Examples:
+//
+// # Extract compiled assets from the builder stage of a multi-stage build
+// $ dagger call build --dir . --target builder extract --path /app/dist export --path ./dist
+func (d *DockerBuild) Extract(
+	ctx context.Context,
+	// the path of the directory to extract from the built image
+	// +required
+	path string,
+	// the platform of the docker image to extract from
+	// +optional
+	// +default="linux/amd64"
+	platform dagger.Platform,
+) (*dagger.Directory, error) {
+	build, err := d.Image(ctx, platform)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := build.Directory(path).Sync(ctx)
+	if err != nil {
+		stage := "final stage"
+		if d.Target != "" {
+			stage = fmt.Sprintf("stage '%s'", d.Target)
+		}
+		return nil, fmt.Errorf("failed to extract directory '%s' from the %s of the build: %w", path, stage, err)
+	}
+
+	return dir, nil
+}
+
+// imageConfig mirrors the config section of an OCI image configuration
+type imageConfig struct {
+	User         string              `json:"User,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+}
+
+// Inspects a built image for a given platform, returning its config as JSON. The config
+// follows the OCI image specification and includes the user, exposed ports, environment
+// variables, entrypoint, default arguments, working directory and labels
+func (d *DockerBuild) Inspect(
+	ctx context.Context,
+	// the platform of the docker image to inspect
+	// +optional
+	// +default="linux/amd64"
+	platform dagger.Platform,
+) (string, error) {
+	build, err := d.Image(ctx, platform)
+	if err != nil {
+		return "", err
+	}
+
+	cfg, err := inspect(ctx, build)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	return string(out), nil
+}
+
+func inspect(ctx context.Context, build *dagger.Container) (imageConfig, error) {
+	cfg := imageConfig{}
+
+	var err error
+	if cfg.User, err = build.User(ctx); err != nil {
+		return imageConfig{}, err
+	}
+
+	if cfg.Entrypoint, err = build.Entrypoint(ctx); err != nil {
+		return imageConfig{}, err
+	}
+
+	if cfg.Cmd, err = build.DefaultArgs(ctx); err != nil {
+		return imageConfig{}, err
+	}
+
+	if cfg.WorkingDir, err = build.Workdir(ctx); err != nil {
+		return imageConfig{}, err
+	}
+
+	envs, err := build.EnvVariables(ctx)
+	if err != nil {
+		return imageConfig{}, err
+	}
+
+	for _, env := range envs {
+		name, err := env.Name(ctx)
+		if err != nil {
+			return imageConfig{}, err
+		}
+
+		value, err := env.Value(ctx)
+		if err != nil {
+			return imageConfig{}, err
+		}
+		cfg.Env = append(cfg.Env, name+"="+value)
+	}
+
+	ports, err := build.ExposedPorts(ctx)
+	if err != nil {
+		return imageConfig{}, err
+	}
+
+	if len(ports) > 0 {
+		cfg.ExposedPorts = map[string]struct{}{}
+		for _, port := range ports {
+			number, err := port.Port(ctx)
+			if err != nil {
+				return imageConfig{}, err
+			}
+
+			protocol, err := port.Protocol(ctx)
+			if err != nil {
+				return imageConfig{}, err
+			}
+			cfg.ExposedPorts[fmt.Sprintf("%d/%s", number, strings.ToLower(string(protocol)))] = struct{}{}
+		}
+	}
+
+	labels, err := build.Labels(ctx)
+	if err != nil {
+		return imageConfig{}, err
+	}
+
+	if len(labels) > 0 {
+		cfg.Labels = map[string]string{}
+		for _, label := range labels {
+			name, err := label.Name(ctx)
+			if err != nil {
+				return imageConfig{}, err
+			}
+
+			value, err := label.Value(ctx)
+			if err != nil {
+				return imageConfig{}, err
+			}
+			cfg.Labels[name] = value
+		}
+	}
+
+	return cfg, nil
+}
+
+// Asserts that a built image for a given platform is configured as expected, failing with
+// a report of every mismatch. Useful for verifying that an image has been hardened
+//
+// Examples:
+//
+// # Assert an image runs as a non-root user and exposes a single port
+// $ dagger call build --dir . assert --non-root --exposed-ports 8080/tcp
+func (d *DockerBuild) Assert(
+	ctx context.Context,
+	// a list of ports that must be exposed by the image, in (port/protocol) format, e.g. 8080/tcp
+	// +optional
+	exposedPorts []string,
+	// the image must define a HEALTHCHECK instruction
+	// +optional
+	healthcheck bool,
+	// a list of labels that must exist on the image, in (key=value) format. If no value
+	// is provided, only the presence of the label is checked
+	// +optional
+	labels []string,
+	// the image must run as a user other than root
+	// +optional
+	nonRoot bool,
+	// the platform of the docker image to assert against
+	// +optional
+	// +default="linux/amd64"
+	platform dagger.Platform,
+	// the user that the image must run as
+	// +optional
+	user string,
+) (*DockerBuild, error) {
+	build, err := d.Image(ctx, platform)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := inspect(ctx, build)
+	if err != nil {
+		return nil, err
+	}
+
+	var failures []string
+	if user != "" && cfg.User != user {
+		failures = append(failures, fmt.Sprintf("expected user '%s' but image runs as '%s'", user, cfg.User))
+	}
+
+	if nonRoot {
+		if name, _, _ := strings.Cut(cfg.User, ":"); name == "" || name == "root" || name == "0" {
+			failures = append(failures, "expected a non-root user but image runs as root")
+		}
+	}
+
+	for _, port := range exposedPorts {
+		if _, found := cfg.ExposedPorts[strings.ToLower(port)]; !found {
+			failures = append(failures, fmt.Sprintf("expected port '%s' to be exposed", port))
+		}
+	}
+
+	for _, label := range labels {
+		key, value, hasValue := strings.Cut(label, "=")
+		actual, found := cfg.Labels[key]
+		switch {
+		case !found:
+			failures = append(failures, fmt.Sprintf("expected label '%s' to exist", key))
+		case hasValue && actual != value:
+			failures = append(failures, fmt.Sprintf("expected label '%s' to be '%s' but was '%s'", key, value, actual))
+		}
+	}
+
+	if healthcheck {
+		found, err := hasHealthcheck(ctx, build)
+		if err != nil {
+			return nil, err
+		}
+
+		if !found {
+			failures = append(failures, "expected a HEALTHCHECK to be defined")
+		}
+	}
+
+	if len(failures) > 0 {
+		return nil, fmt.Errorf("image for platform '%s' failed assertions:\n%s", platform, strings.Join(failures, "\n"))
+	}
+
+	return d, nil
+}
+
+// A subset of the OCI image layout used to locate the config of an image
+type ociDescriptor struct {
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+}
+
+type ociIndex struct {
+	Manifests []ociDescriptor `json:"manifests"`
+}
+
+type ociManifest struct {
+	Config ociDescriptor   `json:"config"`
+	Layers []ociDescriptor `json:"layers"`
+}
+
+type ociConfig struct {
+	Config struct {
+		Healthcheck *struct {
+			Test []string `json:"Test"`
+		} `json:"Healthcheck"`
+	} `json:"config"`
+	History []struct {
+		CreatedBy  string `json:"created_by"`
+		EmptyLayer bool   `json:"empty_layer"`
+	} `json:"history"`
+}
+
+// The healthcheck is not exposed through the dagger API, so it is read directly from
+// the config of the image, after unpacking it as an OCI tarball
+func hasHealthcheck(ctx context.Context, build *dagger.Container) (bool, error) {
+	_, _, config, err := readImage(ctx, ociLayout(build))
+	if err != nil {
+		return false, err
+	}
+
+	hc := config.Config.Healthcheck
+	return hc != nil && len(hc.Test) > 0 && hc.Test[0] != "NONE", nil
+}
+
+// Unpacks a built image as an OCI image layout
+func ociLayout(build *dagger.Container) *dagger.Directory {
+	return dag.Container().
+		From(BusyboxImage).
+		WithMountedFile("/image.tar", build.AsTarball()).
+		WithExec([]string{"sh", "-c", "mkdir -p /oci && tar -xf /image.tar -C /oci index.json blobs"}).
+		Directory("/oci")
+}
+
+// Reads the manifest and config of an image from an OCI image layout
+func readImage(ctx context.Context, layout *dagger.Directory) (ociIndex, ociManifest, ociConfig, error) {
+	var index ociIndex
+	if err := readJSON(ctx, layout, "index.json", &index); err != nil {
+		return ociIndex{}, ociManifest{}, ociConfig{}, err
+	}
+
+	if len(index.Manifests) == 0 {
+		return ociIndex{}, ociManifest{}, ociConfig{}, fmt.Errorf("no manifest exists within image")
+	}
+
+	var manifest ociManifest
+	if err := readJSON(ctx, layout, blobPath(index.Manifests[0].Digest), &manifest); err != nil {
+		return ociIndex{}, ociManifest{}, ociConfig{}, err
+	}
+
+	var config ociConfig
+	if err := readJSON(ctx, layout, blobPath(manifest.Config.Digest), &config); err != nil {
+		return ociIndex{}, ociManifest{}, ociConfig{}, err
+	}
+
+	return index, manifest, config, nil
+}
+
+type layerFile struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+type layerAnalysis struct {
+	Digest       string      `json:"digest"`
+	Size         int64       `json:"size"`
+	CreatedBy    string      `json:"createdBy,omitempty"`
+	LargestFiles []layerFile `json:"largestFiles"`
+}
+
+type imageAnalysis struct {
+	TotalSize    int64           `json:"totalSize"`
+	Layers       []layerAnalysis `json:"layers"`
+	LargestFiles []layerFile     `json:"largestFiles"`
+}
+
+// Analyzes the layers of a built image for a given platform, reporting the compressed size
+// of each layer, the instruction that created it, and its largest files. The largest files
+// across the entire image are also reported. Useful for identifying files that have been
+// unintentionally copied into an image, such as a build cache
+//
+// Examples:
+//
+// # Report the 5 largest files within each layer
+// $ dagger call build --dir . analyze --top 5
+func (d *DockerBuild) Analyze(
+	ctx context.Context,
+	// the platform of the docker image to analyze
+	// +optional
+	// +default="linux/amd64"
+	platform dagger.Platform,
+	// the number of largest files to report
+	// +optional
+	// +default=10
+	top int,
+) (string, error) {
+	build, err := d.Image(ctx, platform)
+	if err != nil {
+		return "", err
+	}
+
+	layout := ociLayout(build)
+	_, manifest, config, err := readImage(ctx, layout)
+	if err != nil {
+		return "", err
+	}
+
+	// Empty layers are recorded within the history, but not the manifest
+	var createdBy []string
+	for _, h := range config.History {
+		if !h.EmptyLayer {
+			createdBy = append(createdBy, h.CreatedBy)
+		}
+	}
+
+	lister := dag.Container().
+		From(BusyboxImage).
+		WithMountedDirectory("/oci", layout)
+
+	analysis := imageAnalysis{}
+	var allFiles []layerFile
+	for i, layer := range manifest.Layers {
+		out, err := lister.WithExec([]string{"tar", "-tvf", path.Join("/oci", blobPath(layer.Digest))}).Stdout(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		files := parseTarListing(out)
+		allFiles = append(allFiles, files...)
+
+		la := layerAnalysis{
+			Digest:       layer.Digest,
+			Size:         layer.Size,
+			LargestFiles: largestFiles(files, top),
+		}
+
+		if i < len(createdBy) {
+			la.CreatedBy = createdBy[i]
+		}
+
+		analysis.TotalSize += layer.Size
+		analysis.Layers = append(analysis.Layers, la)
+	}
+	analysis.LargestFiles = largestFiles(allFiles, top)
+
+	out, err := json.MarshalIndent(analysis, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	return string(out), nil
+}
+
+// Parses the verbose output of busybox tar, only retaining regular files:
+// -rw-r--r-- 0/0       1234 2024-01-01 00:00:00 usr/bin/app
+func parseTarListing(out string) []layerFile {
+	var files []layerFile
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 6 || !strings.HasPrefix(fields[0], "-") {
+			continue
+		}
+
+		size, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		files = append(files, layerFile{Path: strings.Join(fields[5:], " "), Size: size})
+	}
+
+	return files
+}
+
+func largestFiles(files []layerFile, top int) []layerFile {
+	sorted := slices.Clone(files)
+	slices.SortStableFunc(sorted, func(a, b layerFile) int {
+		return cmp.Compare(b.Size, a.Size)
+	})
+
+	if len(sorted) > top {
+		sorted = sorted[:top]
+	}
+	return sorted
+}
+
+func blobPath(digest string) string {
+	return "blobs/" + strings.Replace(digest, ":", "/", 1)
+}
+
+func readJSON(ctx context.Context, dir *dagger.Directory, path string, v any) error {
+	contents, err := dir.File(path).Contents(ctx)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal([]byte(contents), v)
+}
+
 // Publish the built image to a target registry. Supports publishing of mulit-platform images
+//
+// Examples:
+//
+// # Publish an image with an externally generated CycloneDX SBOM attached as a referrer
+// $ dagger call build --dir . publish --ref ttl.sh/example --referrers sbom.cdx.json --referrer-types application/vnd.cyclonedx+json
 func (d *DockerBuild) Publish(
 	ctx context.Context,
 	// a fully qualified image reference without tags
@@ -181,11 +944,52 @@ func (d *DockerBuild) Publish(
 	// +optional
 	// +default=["latest"]
 	tags []string,
+	// a semantic version (e.g. 1.2.3) that is expanded into a hierarchy of tags
+	// (1.2.3, 1.2, 1, latest) and published alongside any other tags. Prerelease
+	// versions (e.g. 1.2.3-beta.1) are published as is
+	// +optional
+	semverTags string,
+	// generate a SLSA provenance attestation, in the in-toto format, describing how the image
+	// was built and attach it to the published image. Attestations are attached as referrers,
+	// requiring a registry that supports the OCI 1.1 referrers API. Registries without support
+	// fallback to the referrers tag schema, where an additional sha256-<digest> tag is created
+	// +optional
+	provenance bool,
+	// generate an SPDX SBOM for each platform of the published image using syft and attach it
+	// to the published image. Has the same registry requirements as a provenance attestation
+	// +optional
+	sbom bool,
+	// a list of artifacts, such as an externally generated SBOM or signature, to attach to the
+	// published image as referrers. Each is discoverable through oras discover, and has the
+	// same registry requirements as a provenance attestation
+	// +optional
+	referrers []*dagger.File,
+	// the artifact type of each referrer (e.g. application/vnd.cyclonedx+json). Must match
+	// the order of the provided referrers
+	// +optional
+	referrerTypes []string,
+	// the media types of the published image index and manifests (OCIMediaTypes, DockerMediaTypes)
+	// +optional
+	// +default="OCIMediaTypes"
+	mediaTypes dagger.ImageMediaTypes,
 ) (string, error) {
+	if len(referrers) != len(referrerTypes) {
+		return "", fmt.Errorf("an artifact type must be provided for each referrer, %d types provided for %d referrers",
+			len(referrerTypes), len(referrers))
+	}
+
 	// Sanitise the ref, stripping off any tags or trailing forward slashes that may
 	// have accidentally been included due to dynamic CI variables
 	imgRef := strings.TrimRight(ref, ":/")
 
+	if semverTags != "" {
+		expanded, err := expandSemver(semverTags)
+		if err != nil {
+			return "", err
+		}
+		tags = dedupe(append(tags, expanded...))
+	}
+
 	ctr := dag.Container()
 	if d.Auth != nil {
 		ctr = ctr.WithRegistryAuth(d.Auth.Registry, d.Auth.Username, d.Auth.Password)
@@ -203,6 +1007,7 @@ func (d *DockerBuild) Publish(
 			dagger.ContainerPublishOpts{
 				PlatformVariants:  d.Builds,
 				ForcedCompression: dagger.Gzip,
+				MediaTypes:        mediaTypes,
 			},
 		)
 		if err != nil {
@@ -211,5 +1016,225 @@ func (d *DockerBuild) Publish(
 		imageRefs = append(imageRefs, imageRef)
 	}
 
+	if len(imageRefs) == 0 || (!provenance && !sbom && len(referrers) == 0) {
+		return strings.Join(imageRefs, "\n"), nil
+	}
+
+	// All tags share the same digest, so attestations only need attaching once
+	_, digest, _ := strings.Cut(imageRefs[0], "@")
+	subject := imgRef + "@" + digest
+
+	if provenance {
+		statement, err := d.provenance(ctx, imgRef, digest)
+		if err != nil {
+			return "", err
+		}
+
+		if err := d.attach(ctx, subject, inTotoMediaType, asFile("provenance.json", statement), ""); err != nil {
+			return "", err
+		}
+		imageRefs = append(imageRefs, "Attested: provenance")
+	}
+
+	if sbom {
+		for _, build := range d.Builds {
+			platform, err := build.Platform(ctx)
+			if err != nil {
+				return "", err
+			}
+
+			spdx, err := dag.Container().
+				From(SyftImage).
+				WithMountedFile("/tmp/image.tar", build.AsTarball()).
+				WithExec([]string{"scan", "oci-archive:/tmp/image.tar", "--output", "spdx-json"},
+					dagger.ContainerWithExecOpts{UseEntrypoint: true}).
+				Stdout(ctx)
+			if err != nil {
+				return "", err
+			}
+
+			if err := d.attach(ctx, subject, spdxMediaType, asFile("sbom.spdx.json", spdx), string(platform)); err != nil {
+				return "", err
+			}
+			imageRefs = append(imageRefs, fmt.Sprintf("Attested: sbom (%s)", platform))
+		}
+	}
+
+	for i, referrer := range referrers {
+		if err := d.attach(ctx, subject, referrerTypes[i], referrer, ""); err != nil {
+			return "", err
+		}
+
+		name, err := referrer.Name(ctx)
+		if err != nil {
+			return "", err
+		}
+		imageRefs = append(imageRefs, fmt.Sprintf("Referrer: %s (%s)", name, referrerTypes[i]))
+	}
+
 	return strings.Join(imageRefs, "\n"), nil
 }
+
+type inTotoStatement struct {
+	Type          string          `json:"_type"`
+	Subject       []inTotoSubject `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     slsaPredicate   `json:"predicate"`
+}
+
+type inTotoSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+type slsaPredicate struct {
+	BuildDefinition struct {
+		BuildType          string         `json:"buildType"`
+		ExternalParameters map[string]any `json:"externalParameters"`
+	} `json:"buildDefinition"`
+	RunDetails struct {
+		Builder struct {
+			ID string `json:"id"`
+		} `json:"builder"`
+		Metadata struct {
+			FinishedOn string `json:"finishedOn"`
+		} `json:"metadata"`
+	} `json:"runDetails"`
+}
+
+// Generates a SLSA provenance attestation for the published image. Build arguments
+// are deliberately excluded as they may contain sensitive values
+func (d *DockerBuild) provenance(ctx context.Context, name, digest string) (string, error) {
+	algorithm, hex, found := strings.Cut(digest, ":")
+	if !found {
+		return "", fmt.Errorf("malformed digest '%s' for published image", digest)
+	}
+
+	var platforms []string
+	for _, build := range d.Builds {
+		platform, err := build.Platform(ctx)
+		if err != nil {
+			return "", err
+		}
+		platforms = append(platforms, string(platform))
+	}
+
+	pred := slsaPredicate{}
+	pred.BuildDefinition.BuildType = dockerBuildType
+	pred.BuildDefinition.ExternalParameters = map[string]any{
+		"dockerfile": d.Dockerfile,
+		"platforms":  platforms,
+	}
+
+	if d.Target != "" {
+		pred.BuildDefinition.ExternalParameters["target"] = d.Target
+	}
+	pred.RunDetails.Builder.ID = dockerBuilderID
+	pred.RunDetails.Metadata.FinishedOn = time.Now().UTC().Format(time.RFC3339)
+
+	statement := inTotoStatement{
+		Type: inTotoStatementType,
+		Subject: []inTotoSubject{
+			{Name: name, Digest: map[string]string{algorithm: hex}},
+		},
+		PredicateType: slsaProvenanceType,
+		Predicate:     pred,
+	}
+
+	out, err := json.MarshalIndent(statement, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	return string(out), nil
+}
+
+// Attaches a file to the subject image as an OCI referrer using oras. An optional
+// platform is recorded as an annotation on the attached artifact
+func (d *DockerBuild) attach(ctx context.Context, subject, artifactType string, file *dagger.File, platform string) error {
+	name, err := file.Name(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctr := dag.Container().
+		From(OrasImage).
+		WithUser("root").
+		WithWorkdir("/tmp/attach").
+		WithFile(name, file)
+
+	cmd := []string{"attach", "--artifact-type", artifactType}
+	if d.Auth != nil {
+		ctr = ctr.WithMountedSecret(OrasRegistryConfig, registryConfig(d.Auth))
+		cmd = append(cmd, "--registry-config", OrasRegistryConfig)
+	}
+
+	if platform != "" {
+		cmd = append(cmd, "--annotation", fmt.Sprintf("%s=%s", platformAnnotation, platform))
+	}
+	cmd = append(cmd, subject, fmt.Sprintf("%s:%s", name, artifactType))
+
+	_, err = ctr.WithExec(cmd, dagger.ContainerWithExecOpts{UseEntrypoint: true}).Sync(ctx)
+	return err
+}
+
+func asFile(name, contents string) *dagger.File {
+	return dag.Directory().WithNewFile(name, contents).File(name)
+}
+
+// Generates a docker config file containing the registry credentials
+func registryConfig(auth *DockerAuth) *dagger.Secret {
+	// Docker Hub credentials are always stored against its legacy index address
+	registry := auth.Registry
+	if registry == "docker.io" {
+		registry = "https://index.docker.io/v1/"
+	}
+
+	return dag.OciLogin().
+		WithAuth(registry, auth.Username, auth.Password).
+		AsSecret(dagger.OciLoginAsSecretOpts{})
+}
+
+func expandSemver(version string) ([]string, error) {
+	ver := strings.TrimPrefix(strings.TrimSpace(version), "v")
+
+	// Image tags do not support build metadata, so it is discarded
+	ver, _, _ = strings.Cut(ver, "+")
+	core, prerelease, _ := strings.Cut(ver, "-")
+
+	parts := strings.Split(core, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed semantic version '%s', expected format major.minor.patch", version)
+	}
+
+	for _, part := range parts {
+		if _, err := strconv.ParseUint(part, 10, 64); err != nil {
+			return nil, fmt.Errorf("malformed semantic version '%s', expected format major.minor.patch", version)
+		}
+	}
+
+	if prerelease != "" {
+		return []string{ver}, nil
+	}
+
+	return []string{
+		core,
+		parts[0] + "." + parts[1],
+		parts[0],
+		"latest",
+	}, nil
+}
+
+func dedupe(values []string) []string {
+	seen := map[string]struct{}{}
+
+	var unique []string
+	for _, v := range values {
+		if _, ok := seen[v]; ok {
+			continue
+		}
+		seen[v] = struct{}{}
+		unique = append(unique, v)
+	}
+	return unique
+}
@@ -8,11 +8,19 @@ package main
 import (
 	"context"
 	"dagger/golang/internal/dagger"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
+	"path"
 	"runtime"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
 )
 
 const (
@@ -23,9 +31,24 @@ const (
 	go1_19 = "golang:1.19.13-bullseye"
 	go1_20 = "golang:1.20.13-bookworm"
 
-	goMod     = "go.mod"
-	goWorkDir = "/src"
-	netrcPath = "/root/.netrc"
+	// An image capable of generating both tar.gz and zip archives, without installing
+	// any additional tooling
+	archiveImage = "python:3.13.0-alpine3.20"
+
+	archiveDir      = "/archive"
+	benchBaseline   = "/tmp/bench/baseline.txt"
+	benchResults    = "/tmp/bench/results.txt"
+	binDir          = "/tmp/golang/bin"
+	cacheBusterEnv  = "DAGGER_CACHE_BUSTER"
+	coverProfile    = "/tmp/golang/coverage.out"
+	goMod           = "go.mod"
+	goWorkDir       = "/src"
+	goWorkFile      = "/tmp/golang/go.work"
+	lintSarifReport = "/tmp/golang/golangci-lint.sarif"
+	netrcPath       = "/root/.netrc"
+	provenanceFile  = "provenance.json"
+	replaceDir      = "/replace"
+	runBinary       = "/tmp/golang/run"
 )
 
 // Enables support for accessing private Go modules as project dependencies
@@ -59,6 +82,15 @@ type Golang struct {
 	// Version of the go project, defined within the go.mod file
 	// +private
 	Version string
+
+	// WorkDir is the directory within the container containing the go.mod file
+	// +private
+	WorkDir string
+
+	// Mod controls how the go command updates and uses the go.mod file, passed through
+	// the -mod flag when building, testing and vetting
+	// +private
+	Mod string
 }
 
 // New initializes the golang dagger module
@@ -73,12 +105,27 @@ func New(
 	// a path to a directory containing the source code
 	// +required
 	src *dagger.Directory,
+	// a relative path to the Go module within the source directory, used when
+	// the go.mod file is not located within the root of the project
+	// +optional
+	subdir string,
+	// control how the go command uses the go.mod file, through the -mod flag (mod, vendor,
+	// readonly). Applies when building, testing and vetting the project. If omitted, go
+	// uses vendor mode by default when a vendor directory exists within the project
+	// +optional
+	mod string,
 ) (*Golang, error) {
-	version, err := inspectModVersion(context.Background(), src)
+	version, err := inspectModVersion(context.Background(), src, subdir)
 	if err != nil {
 		return nil, err
 	}
 
+	switch mod {
+	case "", "mod", "vendor", "readonly":
+	default:
+		return nil, fmt.Errorf("unsupported mod value '%s', expected one of (mod,vendor,readonly)", mod)
+	}
+
 	if base == nil {
 		base = defaultImage(version)
 	} else {
@@ -88,16 +135,17 @@ func New(
 	}
 
 	// Ensure cache mounts are configured for any type of image
+	workDir := path.Join(goWorkDir, subdir)
 	base = mountCaches(ctx, base).
 		WithDirectory(goWorkDir, src).
-		WithWorkdir(goWorkDir).
+		WithWorkdir(workDir).
 		WithoutEntrypoint()
 
-	return &Golang{Base: base, Src: src, Version: version}, nil
+	return &Golang{Base: base, Src: src, Version: version, WorkDir: workDir, Mod: mod}, nil
 }
 
-func inspectModVersion(ctx context.Context, src *dagger.Directory) (string, error) {
-	mod, err := src.File(goMod).Contents(ctx)
+func inspectModVersion(ctx context.Context, src *dagger.Directory, subdir string) (string, error) {
+	mod, err := src.File(path.Join(subdir, goMod)).Contents(ctx)
 	if err != nil {
 		return "", err
 	}
@@ -121,8 +169,18 @@ func mountCaches(ctx context.Context, base *dagger.Container) *dagger.Container
 		WithMountedCache(goCacheEnv, gobuild)
 }
 
+func withoutCaches(ctx context.Context, base *dagger.Container) *dagger.Container {
+	goCacheEnv, _ := base.WithExec([]string{"go", "env", "GOCACHE"}).Stdout(ctx)
+	goModCacheEnv, _ := base.WithExec([]string{"go", "env", "GOMODCACHE"}).Stdout(ctx)
+
+	return base.
+		WithoutMount(goModCacheEnv).
+		WithoutMount(goCacheEnv)
+}
+
 // Echoes the version of go defined within a projects go.mod file.
-// It expects the go.mod file to be located within the root of the project
+// It expects the go.mod file to be located within the root of the project,
+// or the subdirectory provided during initialization
 func (g *Golang) ModVersion() string {
 	return g.Version
 }
@@ -175,6 +233,40 @@ func (g *Golang) WithPrivate(
 	return g
 }
 
+// Replaces a module dependency with a local directory, such as an unreleased version of a
+// shared library checked out alongside the project. The replacement is made within a go.work
+// file that exists outside of the project, leaving its go.mod file untouched. Any go.work
+// file within the project is ignored. Each call will replace an additional module
+//
+// Examples:
+//
+// # Test against a local checkout of a shared library
+// $ dagger call --src . with-replace --module github.com/example/shared --dir ../shared test
+func (g *Golang) WithReplace(
+	// the path of the module to replace, e.g. github.com/example/shared
+	// +required
+	module string,
+	// a path to a directory containing the replacement module
+	// +required
+	dir *dagger.Directory,
+) (*Golang, error) {
+	if g.Version == "1.17" {
+		return nil, fmt.Errorf("replacing modules through a go.work file requires go versions 1.18 and higher")
+	}
+
+	if g.Mod == "mod" || g.Mod == "vendor" {
+		return nil, fmt.Errorf("replacing modules through a go.work file is not supported with mod %s, workspace mode only supports readonly", g.Mod)
+	}
+
+	target := path.Join(replaceDir, module)
+	g.Base = g.Base.
+		WithDirectory(target, dir).
+		WithEnvVariable("GOWORK", goWorkFile).
+		WithExec([]string{"sh", "-c", fmt.Sprintf("mkdir -p %[1]s && ([ -f %[2]s ] || go work init %[3]s)", path.Dir(goWorkFile), goWorkFile, g.WorkDir)}).
+		WithExec([]string{"go", "work", "edit", fmt.Sprintf("-replace=%s=%s", module, target)})
+	return g, nil
+}
+
 // Enable private Go module support by loading an existing .netrc auto-login configuration
 // file. Each call will append a new auto-login configuration
 func (g *Golang) WithPrivateLoad(
@@ -198,12 +290,12 @@ func (g *Golang) WithPrivateLoad(
 	return g
 }
 
-func (g *Golang) enablePrivateModules() *dagger.Container {
+func (g *Golang) enablePrivateModules(ctr *dagger.Container) *dagger.Container {
 	if g.Private == nil {
-		return g.Base
+		return ctr
 	}
 
-	return g.Base.
+	return ctr.
 		WithEnvVariable("GOPRIVATE", strings.Join(g.Private.Modules, ",")).
 		WithEnvVariable("NETRC", netrcPath).
 		WithMountedSecret(netrcPath, g.Private.Netrc.AsSecret())
@@ -212,6 +304,7 @@ func (g *Golang) enablePrivateModules() *dagger.Container {
 // Build a static binary from a Go project using the provided configuration.
 // A directory is returned containing the built binary.
 func (g *Golang) Build(
+	ctx context.Context,
 	// the path to the main.go file of the project
 	// +optional
 	main string,
@@ -229,7 +322,27 @@ func (g *Golang) Build(
 	// +optional
 	// +default=["-s", "-w"]
 	ldflags []string,
-) *dagger.Directory {
+	// generate a provenance.json file alongside the built binary, capturing the
+	// go version, module path, VCS details and build flags used during the build
+	// +optional
+	provenance bool,
+	// build without the mounted build and module caches, and without reusing any previously
+	// cached build, guaranteeing a clean build. Useful for verifying that builds are
+	// reproducible, but will be slower as nothing is cached and all dependencies are
+	// downloaded and compiled from scratch
+	// +optional
+	noCache bool,
+	// control whether the binary is stamped with version control information, through
+	// the -buildvcs flag (auto, true, false). Disabling this is useful when building from
+	// a checkout that git cannot inspect, such as a detached or shallow clone. Defaults
+	// to the behavior of go build
+	// +optional
+	buildvcs string,
+) (*dagger.Directory, error) {
+	if err := validateBuildVcs(buildvcs, g.Version); err != nil {
+		return nil, err
+	}
+
 	if os == "" {
 		os = runtime.GOOS
 	}
@@ -238,184 +351,1688 @@ func (g *Golang) Build(
 		arch = runtime.GOARCH
 	}
 
-	cmd := []string{"go", "build", "-ldflags", strings.Join(ldflags, " ")}
-	if out != "" {
-		cmd = append(cmd, "-o", out)
+	ctr := g.build(ctx, buildOpts{
+		Main:     main,
+		Out:      out,
+		Os:       os,
+		Arch:     arch,
+		Ldflags:  ldflags,
+		NoCache:  noCache,
+		BuildVcs: buildvcs,
+	})
+
+	dir := ctr.Directory(g.WorkDir)
+	if !provenance {
+		return dir, nil
 	}
 
-	if main != "" {
-		cmd = append(cmd, main)
+	bin, err := binaryName(ctx, ctr, main, out, os)
+	if err != nil {
+		return nil, err
 	}
 
-	ctr := g.Base
-	if g.Private != nil {
-		ctr = g.enablePrivateModules()
+	buildInfo, err := ctr.WithExec([]string{"go", "version", "-m", bin}).Stdout(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	return ctr.
-		WithEnvVariable("CGO_ENABLED", "0").
-		WithEnvVariable("GOOS", os).
-		WithEnvVariable("GOARCH", arch).
-		WithExec(cmd).
-		Directory(goWorkDir)
+	prov := parseBuildInfo(buildInfo)
+	prov.Binary = bin
+	prov.GoVersion = g.Version
+
+	data, err := json.MarshalIndent(prov, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return dir.WithNewFile(provenanceFile, string(data), dagger.DirectoryWithNewFileOpts{Permissions: 0o644}), nil
 }
 
-// Execute tests defined within the target project, ignores benchmarks by default
-func (g *Golang) Test(
+// Build a static binary for every main package within a monorepo, discovered as
+// `<cmdDir>/<name>/main.go`. Each binary is named after its directory, and built using
+// the same configuration. A directory is returned containing all of the built binaries
+//
+// Examples:
+//
+// # Build every binary within the cmd directory, such as cmd/api and cmd/worker
+// $ dagger call --src . build-all
+//
+// # Build every binary for linux arm64
+// $ dagger call --src . build-all --os linux --arch arm64
+func (g *Golang) BuildAll(
 	ctx context.Context,
-	// if only short running tests should be executed
+	// the path to the directory containing a subdirectory for each main package
 	// +optional
-	// +default=true
-	short bool,
-	// if the tests should be executed out of order
+	// +default="cmd"
+	cmdDir string,
+	// the target operating system
 	// +optional
-	// +default=true
-	shuffle bool,
-	// run select tests only, defined using a regex
+	os string,
+	// the target architecture
 	// +optional
-	run string,
-	// skip select tests, defined using a regex
+	arch string,
+	// flags to configure the linking during a build, by default sets flags for
+	// generating a release binary
 	// +optional
-	skip string,
-) (string, error) {
-	cmd := []string{"go", "test", "-vet=off", "-covermode=atomic", "./..."}
-	if short {
-		cmd = append(cmd, "-short")
+	// +default=["-s", "-w"]
+	ldflags []string,
+	// control whether each binary is stamped with version control information, through
+	// the -buildvcs flag (auto, true, false). Defaults to the behavior of go build
+	// +optional
+	buildvcs string,
+) (*dagger.Directory, error) {
+	if err := validateBuildVcs(buildvcs, g.Version); err != nil {
+		return nil, err
 	}
 
-	if shuffle {
-		cmd = append(cmd, "-shuffle=on")
+	if os == "" {
+		os = runtime.GOOS
 	}
 
-	if run != "" {
-		cmd = append(cmd, []string{"-run", run}...)
+	if arch == "" {
+		arch = runtime.GOARCH
 	}
 
-	if skip != "" {
-		cmd = append(cmd, []string{"-skip", skip}...)
+	cmdDir = strings.Trim(path.Clean(cmdDir), "/")
+	mains, err := g.Base.Directory(g.WorkDir).Glob(ctx, path.Join(cmdDir, "*", "main.go"))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(mains) == 0 {
+		return nil, fmt.Errorf("no main packages found within %s, expected %s/<name>/main.go", cmdDir, cmdDir)
+	}
+
+	dir := dag.Directory()
+	for _, main := range mains {
+		name := path.Base(path.Dir(main))
+		if os == "windows" {
+			name += ".exe"
+		}
+		out := path.Join(binDir, name)
+
+		ctr := g.build(ctx, buildOpts{
+			Main:     "./" + path.Dir(main),
+			Out:      out,
+			Os:       os,
+			Arch:     arch,
+			Ldflags:  ldflags,
+			BuildVcs: buildvcs,
+		})
+		dir = dir.WithFile(name, ctr.File(out))
+	}
+
+	return dir, nil
+}
+
+func validateBuildVcs(buildvcs, version string) error {
+	switch buildvcs {
+	case "", "auto", "true", "false":
+	default:
+		return fmt.Errorf("unsupported buildvcs value '%s', expected one of (auto,true,false)", buildvcs)
+	}
+
+	if buildvcs != "" && version == "1.17" {
+		return fmt.Errorf("the -buildvcs flag is supported by go versions 1.18 and higher")
+	}
+
+	return nil
+}
+
+type buildOpts struct {
+	Main     string
+	Out      string
+	Os       string
+	Arch     string
+	Ldflags  []string
+	NoCache  bool
+	BuildVcs string
+}
+
+func (g *Golang) build(ctx context.Context, opts buildOpts) *dagger.Container {
+	cmd := withModFlag([]string{"go", "build", "-ldflags", strings.Join(opts.Ldflags, " ")}, g.Mod)
+	if opts.Out != "" {
+		cmd = append(cmd, "-o", opts.Out)
+	}
+
+	if opts.BuildVcs != "" {
+		cmd = append(cmd, "-buildvcs="+opts.BuildVcs)
+	}
+
+	if opts.Main != "" {
+		cmd = append(cmd, opts.Main)
 	}
 
 	ctr := g.Base
+	if opts.NoCache {
+		ctr = bustCache(withoutCaches(ctx, ctr))
+	}
+
 	if g.Private != nil {
-		ctr = g.enablePrivateModules()
+		ctr = g.enablePrivateModules(ctr)
 	}
 
-	return ctr.WithExec(cmd).Stdout(ctx)
+	return ctr.
+		WithEnvVariable("CGO_ENABLED", "0").
+		WithEnvVariable("GOOS", opts.Os).
+		WithEnvVariable("GOARCH", opts.Arch).
+		WithExec(cmd)
 }
 
-// Execute benchmarks defined within the target project, excludes all other tests
-func (g *Golang) Bench(
+// Build a static binary from a Go project and package it into a release archive, alongside
+// any additional files, such as a LICENSE or README. Archives are named using the convention
+// `<name>_<os>_<arch>`, and are compressed as a .tar.gz, or a .zip when targeting windows
+//
+// Examples:
+//
+// # Package a linux binary with its license and readme
+// $ dagger call archive --os linux --arch amd64 --files LICENSE --files README.md
+func (g *Golang) Archive(
 	ctx context.Context,
-	// print memory allocation statistics for benchmarks
+	// the path to the main.go file of the project
 	// +optional
-	// +default=true
-	memory bool,
-	// the time.Duration each benchmark should run for
+	main string,
+	// the name of the built binary
 	// +optional
-	// +default="5s"
-	time string,
-) (string, error) {
-	cmd := []string{"go", "test", "-bench=.", "-benchtime", time, "-run=^#", "./..."}
-	if memory {
-		cmd = append(cmd, "-benchmem")
+	out string,
+	// the target operating system
+	// +optional
+	os string,
+	// the target architecture
+	// +optional
+	arch string,
+	// flags to configure the linking during a build, by default sets flags for
+	// generating a release binary
+	// +optional
+	// +default=["-s", "-w"]
+	ldflags []string,
+	// a list of additional files to include within the archive
+	// +optional
+	files []*dagger.File,
+	// a name for the archive, defaults to the name of the built binary
+	// +optional
+	name string,
+) (*dagger.File, error) {
+	if os == "" {
+		os = runtime.GOOS
 	}
 
-	ctr := g.Base
-	if g.Private != nil {
-		ctr = g.enablePrivateModules()
+	if arch == "" {
+		arch = runtime.GOARCH
 	}
 
-	return ctr.WithExec(cmd).Stdout(ctx)
-}
+	ctr := g.build(ctx, buildOpts{
+		Main:    main,
+		Out:     out,
+		Os:      os,
+		Arch:    arch,
+		Ldflags: ldflags,
+	})
 
-// Scans the target project for vulnerabilities using govulncheck
-func (g *Golang) Vulncheck(ctx context.Context) (string, error) {
-	if g.Version == "1.17" {
-		return "", fmt.Errorf("govulncheck supports go versions 1.18 and higher")
+	bin, err := binaryName(ctx, ctr, main, out, os)
+	if err != nil {
+		return nil, err
 	}
 
-	ctr := g.Base
-	if _, err := ctr.WithExec([]string{"govulncheck", "--version"}).Sync(ctx); err != nil {
-		tag, err := dag.Github().GetLatestRelease("golang/vuln").Tag(ctx)
-		if err != nil {
-			return "", err
-		}
-
-		ctr = ctr.WithExec([]string{"go", "install", "golang.org/x/vuln/cmd/govulncheck@" + tag})
+	if name == "" {
+		name = strings.TrimSuffix(path.Base(bin), ".exe")
 	}
 
-	if g.Private != nil {
-		ctr = g.enablePrivateModules()
+	staging := dag.Directory().
+		WithFile(path.Base(bin), ctr.File(bin)).
+		WithFiles(".", files)
+
+	archive := fmt.Sprintf("%s_%s_%s.tar.gz", name, os, arch)
+	cmd := fmt.Sprintf("tar -czf /dist/%s *", archive)
+	if os == "windows" {
+		archive = fmt.Sprintf("%s_%s_%s.zip", name, os, arch)
+		cmd = fmt.Sprintf("python3 -m zipfile -c /dist/%s *", archive)
 	}
 
-	return ctr.
-		WithExec([]string{"govulncheck", "./..."}).
-		Stdout(ctx)
+	return dag.Container().
+		From(archiveImage).
+		WithDirectory(archiveDir, staging).
+		WithWorkdir(archiveDir).
+		WithExec([]string{"sh", "-c", "mkdir -p /dist && " + cmd}).
+		File(path.Join("/dist", archive)), nil
 }
 
-// Lint the target project using golangci-lint
-func (g *Golang) Lint(
+// Generates a checksums file containing the SHA256 checksum of every artifact within a
+// directory, such as those generated by Archive. The file is compatible with `sha256sum -c`
+//
+// Examples:
+//
+// # Generate a checksums file for all release archives
+// $ dagger call checksums --dir dist
+func (g *Golang) Checksums(
+	// a directory containing the artifacts to checksum, nested directories are ignored
+	// +required
+	dir *dagger.Directory,
+	// the name of the generated checksums file
+	// +optional
+	// +default="checksums.txt"
+	name string,
+) *dagger.File {
+	// The name is passed as a positional argument, ensuring it is never interpreted by the shell
+	cmd := `find . -maxdepth 1 -type f ! -name "$1" -print0 | sort -z | xargs -0 -r sha256sum | sed 's|  \./|  |' > "/tmp/$1"`
+
+	return dag.Container().
+		From(archiveImage).
+		WithDirectory(archiveDir, dir).
+		WithWorkdir(archiveDir).
+		WithExec([]string{"sh", "-c", cmd, "sh", name}).
+		File(path.Join("/tmp", name))
+}
+
+// Reports the size of a binary generated by Build, optionally comparing it against a baseline
+// size and failing if it has grown beyond a threshold. A breakdown of the largest packages and
+// symbols can also be included, generated using go tool nm. A breakdown requires the binary to
+// retain its symbol table, so it must be built without the -s ldflag
+//
+// Examples:
+//
+// # Fail if the binary has grown by more than 5% against a baseline of 12MB
+// $ dagger call binary-size --bin ./dist/app --baseline 12582912 --threshold 5
+//
+// # Report the largest packages and symbols within a binary
+// $ dagger call binary-size --bin ./dist/app --breakdown --top 10
+func (g *Golang) BinarySize(
 	ctx context.Context,
-	// the type of report that should be generated
+	// the binary to report the size of
+	// +required
+	bin *dagger.File,
+	// the size of a previous build of the binary in bytes, used as a baseline for comparison
 	// +optional
-	// +default="colored-line-number"
-	format string,
+	baseline int,
+	// the maximum percentage the binary can grow by against the baseline before failing
+	// +optional
+	// +default=10
+	threshold int,
+	// include a breakdown of the largest packages and symbols within the binary
+	// +optional
+	breakdown bool,
+	// the number of packages and symbols to include within the breakdown
+	// +optional
+	// +default=20
+	top int,
 ) (string, error) {
-	ctr := g.Base
-	if _, err := ctr.WithExec([]string{"golangci-lint", "version"}).Sync(ctx); err != nil {
-		tag, err := dag.Github().GetLatestRelease("golangci/golangci-lint").Tag(ctx)
+	size, err := bin.Size(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var report strings.Builder
+	fmt.Fprintf(&report, "size: %d bytes (%.2f MB)\n", size, float64(size)/(1024*1024))
+
+	var growth float64
+	if baseline > 0 {
+		growth = float64(size-baseline) / float64(baseline) * 100
+		fmt.Fprintf(&report, "baseline: %d bytes (%+d bytes, %+.2f%%)\n", baseline, size-baseline, growth)
+	}
+
+	if breakdown {
+		out, err := g.Base.
+			WithMountedFile("/tmp/golang/bin", bin).
+			WithExec([]string{"go", "tool", "nm", "-size", "-sort", "size", "/tmp/golang/bin"}).
+			Stdout(ctx)
 		if err != nil {
-			return "", err
+			return "", fmt.Errorf("failed to read the symbol table of the binary, ensure it is built without the -s ldflag: %w", err)
 		}
 
-		// Install using the recommended approach: https://golangci-lint.run/welcome/install/
-		cmd := []string{
-			"curl",
-			"-sSfL",
-			"https://raw.githubusercontent.com/golangci/golangci-lint/master/install.sh",
-			"|",
-			"sh",
-			"-s",
-			"--",
-			"-b",
-			"$(go env GOPATH)/bin",
-			tag,
-		}
-		ctr = ctr.WithExec([]string{"bash", "-c", strings.Join(cmd, " ")})
+		report.WriteString(symbolBreakdown(out, top))
 	}
 
-	cmd := []string{
-		"golangci-lint",
-		"run",
-		"--timeout",
-		"5m",
-		"--go",
-		g.Version,
-		"--out-format",
-		format,
+	if baseline > 0 && growth > float64(threshold) {
+		return "", fmt.Errorf("binary has grown by %.2f%%, exceeding the %d%% threshold:\n%s", growth, threshold, report.String())
 	}
 
-	if g.Private != nil {
-		ctr = g.enablePrivateModules()
-	}
+	return report.String(), nil
+}
 
-	return ctr.WithExec(cmd).Stdout(ctx)
+type symbolSize struct {
+	Name string
+	Size int64
 }
 
-// Format the source code within a target project using gofumpt. Formatted code must be
-// copied back onto the host.`
-func (g *Golang) Format(ctx context.Context) (*dagger.Directory, error) {
-	ctr := g.Base
-	if _, err := ctr.WithExec([]string{"gofumpt", "-version"}).Sync(ctx); err != nil {
-		tag, err := dag.Github().GetLatestRelease("mvdan/gofumpt").Tag(ctx)
-		if err != nil {
-			return nil, err
+// Summarizes the output of go tool nm -size -sort size, which is of the format:
+//
+//	4a5b20    1234 T github.com/example/app/cmd.Execute
+//
+// Symbols are aggregated by their package to identify the largest dependencies
+func symbolBreakdown(out string, top int) string {
+	var symbols []symbolSize
+	pkgs := map[string]int64{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
 		}
 
-		ctr = ctr.WithExec([]string{"go", "install", "mvdan.cc/gofumpt@" + tag})
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || size == 0 {
+			continue
+		}
+
+		name := strings.Join(fields[3:], " ")
+		symbols = append(symbols, symbolSize{Name: name, Size: size})
+
+		if pkg := symbolPackage(name); pkg != "" {
+			pkgs[pkg] += size
+		}
 	}
 
-	cmd := []string{"gofumpt", "-w", "-d", "."}
+	var packages []symbolSize
+	for name, size := range pkgs {
+		packages = append(packages, symbolSize{Name: name, Size: size})
+	}
+	sort.Slice(packages, func(i, j int) bool {
+		if packages[i].Size == packages[j].Size {
+			return packages[i].Name < packages[j].Name
+		}
+		return packages[i].Size > packages[j].Size
+	})
+
+	var b strings.Builder
+	b.WriteString("\nlargest packages:\n")
+	for i, pkg := range packages {
+		if i == top {
+			break
+		}
+		fmt.Fprintf(&b, "%10d  %s\n", pkg.Size, pkg.Name)
+	}
+
+	// Symbols are already sorted by size
+	b.WriteString("\nlargest symbols:\n")
+	for i, sym := range symbols {
+		if i == top {
+			break
+		}
+		fmt.Fprintf(&b, "%10d  %s\n", sym.Size, sym.Name)
+	}
+
+	return b.String()
+}
+
+// Extracts the package path from a fully qualified symbol name
+func symbolPackage(name string) string {
+	// Ignore any type parameters of generic symbols, which may contain package paths
+	if idx := strings.Index(name, "["); idx > -1 {
+		name = name[:idx]
+	}
+
+	slash := strings.LastIndex(name, "/")
+	dot := strings.Index(name[slash+1:], ".")
+	if dot == -1 {
+		return ""
+	}
+	return name[:slash+1+dot]
+}
+
+// Build a binary from a Go project and execute it with the provided arguments, returning
+// its output. Useful for smoke testing a build, such as verifying the embedded version of a CLI
+func (g *Golang) Run(
+	ctx context.Context,
+	// the path to the main.go file of the project
+	// +optional
+	main string,
+	// flags to configure the linking during a build, by default sets flags for
+	// generating a release binary
+	// +optional
+	// +default=["-s", "-w"]
+	ldflags []string,
+	// a list of arguments to pass to the built binary
+	// +optional
+	args []string,
+) (string, error) {
+	ctr := g.build(ctx, buildOpts{
+		Main:    main,
+		Out:     runBinary,
+		Os:      runtime.GOOS,
+		Arch:    runtime.GOARCH,
+		Ldflags: ldflags,
+	})
+
+	return ctr.WithExec(append([]string{runBinary}, args...)).Stdout(ctx)
+}
+
+// Build provenance captured from the metadata embedded within a Go binary
+type buildProvenance struct {
+	Binary     string            `json:"binary"`
+	GoVersion  string            `json:"goVersion"`
+	Toolchain  string            `json:"toolchain"`
+	Path       string            `json:"path"`
+	Module     string            `json:"module"`
+	VCS        vcsProvenance     `json:"vcs"`
+	BuildFlags map[string]string `json:"buildFlags"`
+}
+
+type vcsProvenance struct {
+	System   string `json:"system,omitempty"`
+	Revision string `json:"revision,omitempty"`
+	Time     string `json:"time,omitempty"`
+	Modified bool   `json:"modified"`
+}
+
+// Resolves the name of the binary generated by go build, mirroring its default
+// naming convention when no explicit output is provided
+func binaryName(ctx context.Context, ctr *dagger.Container, main, out, os string) (string, error) {
+	if out != "" {
+		return out, nil
+	}
+
+	var name string
+	if strings.HasSuffix(main, ".go") {
+		name = strings.TrimSuffix(path.Base(main), ".go")
+	} else {
+		pkg := main
+		if pkg == "" {
+			pkg = "."
+		}
+
+		importPath, err := ctr.WithExec([]string{"go", "list", "-f", "{{.ImportPath}}", pkg}).Stdout(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		importPath = strings.TrimSpace(importPath)
+		if prefix, _, ok := module.SplitPathVersion(importPath); ok {
+			importPath = prefix
+		}
+		name = path.Base(importPath)
+	}
+
+	if os == "windows" {
+		name += ".exe"
+	}
+	return name, nil
+}
+
+// Parses the output of go version -m, which is of the format:
+//
+//	bin: go1.22.5
+//		path	example.com/app
+//		mod	example.com/app	(devel)
+//		build	-ldflags="-s -w"
+//		build	vcs.revision=6d5ac1c
+func parseBuildInfo(info string) buildProvenance {
+	prov := buildProvenance{BuildFlags: map[string]string{}}
+
+	lines := strings.Split(strings.TrimSpace(info), "\n")
+	if _, toolchain, found := strings.Cut(lines[0], ": "); found {
+		prov.Toolchain = strings.TrimSpace(toolchain)
+	}
+
+	for _, line := range lines[1:] {
+		fields := strings.SplitN(strings.TrimSpace(line), "\t", 3)
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "path":
+			prov.Path = fields[1]
+		case "mod":
+			prov.Module = fields[1]
+		case "build":
+			key, value, _ := strings.Cut(fields[1], "=")
+			value = strings.Trim(value, `"`)
+
+			switch key {
+			case "vcs":
+				prov.VCS.System = value
+			case "vcs.revision":
+				prov.VCS.Revision = value
+			case "vcs.time":
+				prov.VCS.Time = value
+			case "vcs.modified":
+				prov.VCS.Modified = value == "true"
+			default:
+				prov.BuildFlags[key] = value
+			}
+		}
+	}
+
+	return prov
+}
+
+// Execute tests defined within the target project, ignores benchmarks by default
+//
+// Examples:
+//
+// # Run the tests of a single package
+// $ dagger call test --packages ./internal/parser
+func (g *Golang) Test(
+	ctx context.Context,
+	// if only short running tests should be executed
+	// +optional
+	// +default=true
+	short bool,
+	// if the tests should be executed out of order
+	// +optional
+	// +default=true
+	shuffle bool,
+	// run select tests only, defined using a regex
+	// +optional
+	run string,
+	// skip select tests, defined using a regex
+	// +optional
+	skip string,
+	// clear the test cache before executing the tests, ensuring every test is run
+	// rather than any previously cached results being reported
+	// +optional
+	clearCache bool,
+	// only execute example functions, verifying that the output of each example matches
+	// its documented // Output: comment. Cannot be combined with a run regex
+	// +optional
+	examplesOnly bool,
+	// a list of packages to test, defined as import paths or directories relative to
+	// the project root. Defaults to all packages (./...)
+	// +optional
+	packages []string,
+) (string, error) {
+	if examplesOnly {
+		if run != "" {
+			return "", fmt.Errorf("a run regex cannot be provided when only executing examples")
+		}
+		run = "^Example"
+	}
+
+	cmd := withModFlag(testCmd(short, shuffle, run, skip, packages), g.Mod)
+
+	ctr := g.Base
+	if g.Private != nil {
+		ctr = g.enablePrivateModules(ctr)
+	}
+
+	if clearCache {
+		ctr = bustCache(ctr).WithExec([]string{"go", "clean", "-testcache"})
+	}
+
+	return ctr.WithExec(cmd).Stdout(ctx)
+}
+
+// Execute tests defined within the target project against a set of service dependencies,
+// such as a database. Each service is bound to the test container using its alias as a
+// hostname, allowing integration tests to connect to it
+//
+// Examples:
+//
+// # Run integration tests against a postgres database
+// $ dagger call test-with-services --services tcp://localhost:5432 --aliases postgres --tags integration
+func (g *Golang) TestWithServices(
+	ctx context.Context,
+	// a list of aliases for binding each service, used as its hostname. Must match
+	// the order of the provided services
+	// +required
+	aliases []string,
+	// a list of environment variables to set within the test container, expected in
+	// (key=value) format. Useful for providing connection details to each service
+	// +optional
+	env []string,
+	// a list of services to bind to the test container
+	// +required
+	services []*dagger.Service,
+	// if only short running tests should be executed
+	// +optional
+	// +default=true
+	short bool,
+	// if the tests should be executed out of order
+	// +optional
+	// +default=true
+	shuffle bool,
+	// run select tests only, defined using a regex
+	// +optional
+	run string,
+	// skip select tests, defined using a regex
+	// +optional
+	skip string,
+	// a list of build tags to enable, e.g. integration
+	// +optional
+	tags []string,
+) (string, error) {
+	if len(aliases) != len(services) {
+		return "", fmt.Errorf("an alias must be provided for each service, %d aliases provided for %d services",
+			len(aliases), len(services))
+	}
+
+	cmd := withModFlag(testCmd(short, shuffle, run, skip, nil), g.Mod)
+	if len(tags) > 0 {
+		cmd = append(cmd, "-tags", strings.Join(tags, ","))
+	}
+
+	ctr := g.Base
+	if g.Private != nil {
+		ctr = g.enablePrivateModules(ctr)
+	}
+
+	for i, svc := range services {
+		ctr = ctr.WithServiceBinding(aliases[i], svc)
+	}
+
+	for _, e := range env {
+		name, value, found := strings.Cut(e, "=")
+		if !found {
+			return "", fmt.Errorf("failed to parse malformed environment variable '%s', expected (key=value) format", e)
+		}
+		ctr = ctr.WithEnvVariable(name, value)
+	}
+
+	return ctr.WithExec(cmd).Stdout(ctx)
+}
+
+// GolangRaceCoverage contains the result of a test run with both race detection and coverage
+// enabled, it serves as an intermediate type for retrieving the outcome or coverage profile
+type GolangRaceCoverage struct {
+	// +private
+	Ctr *dagger.Container
+}
+
+// Execute tests defined within the target project with the race detector enabled, while also
+// generating a coverage profile. The result of the run must be retrieved through either its
+// report, pass/fail status or coverage profile. The race detector requires cgo, which is
+// enabled for this run, and tests will be significantly slower than the default, making it
+// best suited to a thorough nightly run
+//
+// Examples:
+//
+// # Fail if any test fails or a data race is detected
+// $ dagger call test-race-coverage report
+//
+// # Export the coverage profile, regardless of whether the tests passed
+// $ dagger call test-race-coverage coverage export --path coverage.out
+func (g *Golang) TestRaceCoverage(
+	// if only short running tests should be executed
+	// +optional
+	short bool,
+	// if the tests should be executed out of order
+	// +optional
+	// +default=true
+	shuffle bool,
+	// run select tests only, defined using a regex
+	// +optional
+	run string,
+	// skip select tests, defined using a regex
+	// +optional
+	skip string,
+) *GolangRaceCoverage {
+	// The atomic cover mode set by testCmd is required when using the race detector
+	cmd := append(withModFlag(testCmd(short, shuffle, run, skip, nil), g.Mod), "-race", "-coverprofile", coverProfile)
+
+	ctr := g.Base.
+		WithEnvVariable("CGO_ENABLED", "1").
+		WithExec([]string{"mkdir", "-p", path.Dir(coverProfile)})
+
+	if g.Private != nil {
+		ctr = g.enablePrivateModules(ctr)
+	}
+
+	ctr = ctr.WithExec(cmd, dagger.ContainerWithExecOpts{Expect: dagger.ReturnTypeAny})
+	return &GolangRaceCoverage{Ctr: ctr}
+}
+
+// Returns the output of the test run. Fails if any test failed or a data race was detected
+func (r *GolangRaceCoverage) Report(ctx context.Context) (string, error) {
+	code, err := r.Ctr.ExitCode(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	stdout, err := r.Ctr.Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if code != 0 {
+		stderr, _ := r.Ctr.Stderr(ctx)
+		if strings.Contains(stdout+stderr, "WARNING: DATA RACE") {
+			return "", fmt.Errorf("the race detector discovered a data race:\n%s%s", stdout, stderr)
+		}
+		return "", fmt.Errorf("tests failed:\n%s%s", stdout, stderr)
+	}
+
+	return stdout, nil
+}
+
+// Reports whether all tests passed without the race detector discovering a data race
+func (r *GolangRaceCoverage) Passed(ctx context.Context) (bool, error) {
+	code, err := r.Ctr.ExitCode(ctx)
+	if err != nil {
+		return false, err
+	}
+	return code == 0, nil
+}
+
+// Returns the coverage profile of the test run, which is generated even if tests failed
+func (r *GolangRaceCoverage) Coverage() *dagger.File {
+	return r.Ctr.File(coverProfile)
+}
+
+// Clears the build, test and module caches stored within the cache volumes mounted by
+// this module. Any subsequent build or test will start from a clean state, which can be
+// useful when debugging flaky tests or suspected cache corruption
+func (g *Golang) CleanCache(ctx context.Context) error {
+	_, err := bustCache(g.Base).
+		WithExec([]string{"go", "clean", "-cache", "-testcache", "-modcache"}).
+		Sync(ctx)
+	return err
+}
+
+// Ensures the next command is always executed by dagger, rather than its result being
+// retrieved from the dagger cache. Needed when the command modifies a cache volume
+func bustCache(ctr *dagger.Container) *dagger.Container {
+	return ctr.WithEnvVariable(cacheBusterEnv, strconv.FormatInt(time.Now().UnixNano(), 10))
+}
+
+// Inserts the -mod flag directly after the go subcommand, rather than through GOFLAGS,
+// as it would otherwise break any go install pkg@version within the same container
+func withModFlag(cmd []string, mod string) []string {
+	if mod == "" {
+		return cmd
+	}
+
+	return append([]string{cmd[0], cmd[1], "-mod=" + mod}, cmd[2:]...)
+}
+
+func testCmd(short, shuffle bool, run, skip string, packages []string) []string {
+	if len(packages) == 0 {
+		packages = []string{"./..."}
+	}
+
+	cmd := append([]string{"go", "test", "-vet=off", "-covermode=atomic"}, packages...)
+	if short {
+		cmd = append(cmd, "-short")
+	}
+
+	if shuffle {
+		cmd = append(cmd, "-shuffle=on")
+	}
+
+	if run != "" {
+		cmd = append(cmd, []string{"-run", run}...)
+	}
+
+	if skip != "" {
+		cmd = append(cmd, []string{"-skip", skip}...)
+	}
+
+	return cmd
+}
+
+// GolangFuzz contains the result of a fuzzing session, it serves as an intermediate
+// type for retrieving either the output of the session or its corpus
+type GolangFuzz struct {
+	// +private
+	Ctr *dagger.Container
+	// +private
+	Base *dagger.Container
+	// +private
+	CorpusDir string
+	// +private
+	Target string
+}
+
+// Runs a native fuzz test against a package within the target project, executing
+// no other tests. The result of the fuzzing session must be retrieved through either
+// its output or its corpus
+//
+// Examples:
+//
+// # Fuzz the parser for 10 minutes
+// $ dagger call fuzz --pkg ./parser --target FuzzParse --fuzztime 10m output
+//
+// # Export the corpus, including any crashers, from a fuzzing session
+// $ dagger call fuzz --pkg ./parser --target FuzzParse corpus export --path testdata/fuzz
+func (g *Golang) Fuzz(
+	// the package containing the fuzz test
+	// +optional
+	// +default="."
+	pkg string,
+	// the name of the fuzz test to run, must only match a single fuzz test
+	// +required
+	target string,
+	// the duration of the fuzzing session (e.g. 10m), or the number of iterations
+	// to run (e.g. 1000x)
+	// +optional
+	// +default="1m"
+	fuzztime string,
+) (*GolangFuzz, error) {
+	if g.Version == "1.17" {
+		return nil, fmt.Errorf("native fuzzing supports go versions 1.18 and higher")
+	}
+
+	ctr := g.Base
+	if g.Private != nil {
+		ctr = g.enablePrivateModules(ctr)
+	}
+
+	// Any discovered crasher is written to the testdata/fuzz directory of the package
+	corpus := path.Join(g.WorkDir, pkg, "testdata", "fuzz")
+
+	fuzz := ctr.WithExec(withModFlag([]string{"go", "test", "-run=^$", "-fuzz=" + target, "-fuzztime=" + fuzztime, pkg}, g.Mod),
+		dagger.ContainerWithExecOpts{Expect: dagger.ReturnTypeAny})
+
+	return &GolangFuzz{Ctr: fuzz, Base: ctr, CorpusDir: corpus, Target: target}, nil
+}
+
+// Returns the output of the fuzzing session. Fails if a crasher was discovered, or
+// if the fuzz test could not be run, such as a compilation error
+func (f *GolangFuzz) Output(ctx context.Context) (string, error) {
+	code, err := f.Ctr.ExitCode(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	stdout, err := f.Ctr.Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if code == 0 {
+		return stdout, nil
+	}
+
+	stderr, err := f.Ctr.Stderr(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	// A crasher is only discovered if a new entry was written to the corpus
+	before, err := corpusEntries(ctx, f.Base, path.Join(f.CorpusDir, f.Target))
+	if err != nil {
+		return "", err
+	}
+
+	after, err := corpusEntries(ctx, f.Ctr, path.Join(f.CorpusDir, f.Target))
+	if err != nil {
+		return "", err
+	}
+
+	var crashers []string
+	for _, entry := range after {
+		if !slices.Contains(before, entry) {
+			crashers = append(crashers, path.Join("testdata", "fuzz", f.Target, entry))
+		}
+	}
+
+	if len(crashers) == 0 {
+		return "", fmt.Errorf("fuzzing failed with exit code %d:\n%s", code, stderr)
+	}
+
+	return "", fmt.Errorf("fuzzing discovered a failing input, written to %s:\n%s%s",
+		strings.Join(crashers, ", "), stdout, stderr)
+}
+
+// Lists the entries within the corpus directory of a fuzz test, which may not exist
+func corpusEntries(ctx context.Context, ctr *dagger.Container, dir string) ([]string, error) {
+	out, err := ctr.WithExec([]string{"sh", "-c", fmt.Sprintf("ls -1 %s 2>/dev/null || true", dir)}).Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Fields(out), nil
+}
+
+// Returns the corpus of the fuzz test, including any seed inputs and discovered crashers.
+// Crashers should be committed to ensure they are permanently tested against
+func (f *GolangFuzz) Corpus() *dagger.Directory {
+	// The corpus will not exist if no seed inputs or crashers exist
+	return f.Ctr.
+		WithExec([]string{"mkdir", "-p", f.CorpusDir}).
+		Directory(f.CorpusDir)
+}
+
+// Execute tests defined within the target project against multiple versions of Go.
+// Each version is tested within its official Go image, with a summary reporting
+// whether the tests passed or failed for each version
+func (g *Golang) TestMatrix(
+	ctx context.Context,
+	// a list of Go versions to test against (e.g. 1.21, 1.22, 1.23)
+	// +required
+	versions []string,
+	// if only short running tests should be executed
+	// +optional
+	// +default=true
+	short bool,
+	// if the tests should be executed out of order
+	// +optional
+	// +default=true
+	shuffle bool,
+	// run select tests only, defined using a regex
+	// +optional
+	run string,
+	// skip select tests, defined using a regex
+	// +optional
+	skip string,
+) (string, error) {
+	if len(versions) == 0 {
+		return "", fmt.Errorf("at least one go version must be provided")
+	}
+
+	cmd := withModFlag(testCmd(short, shuffle, run, skip, nil), g.Mod)
+
+	var summary strings.Builder
+	var failed []string
+	var failures strings.Builder
+	for _, version := range versions {
+		ctr := mountCaches(ctx, defaultImage(version)).
+			WithDirectory(goWorkDir, g.Src).
+			WithWorkdir(g.WorkDir).
+			WithoutEntrypoint()
+
+		if g.Private != nil {
+			ctr = g.enablePrivateModules(ctr)
+		}
+
+		if _, err := ctr.WithExec(cmd).Sync(ctx); err != nil {
+			failed = append(failed, version)
+			fmt.Fprintf(&summary, "go %s: FAIL\n", version)
+			fmt.Fprintf(&failures, "\n=== go %s ===\n%s\n", version, err)
+			continue
+		}
+		fmt.Fprintf(&summary, "go %s: PASS\n", version)
+	}
+
+	if len(failed) > 0 {
+		return "", fmt.Errorf("tests failed for go versions: %s\n\n%s%s",
+			strings.Join(failed, ", "), summary.String(), failures.String())
+	}
+
+	return summary.String(), nil
+}
+
+// Execute benchmarks defined within the target project, excludes all other tests
+func (g *Golang) Bench(
+	ctx context.Context,
+	// print memory allocation statistics for benchmarks
+	// +optional
+	// +default=true
+	memory bool,
+	// the time.Duration each benchmark should run for
+	// +optional
+	// +default="5s"
+	time string,
+) (string, error) {
+	cmd := withModFlag([]string{"go", "test", "-bench=.", "-benchtime", time, "-run=^#", "./..."}, g.Mod)
+	if memory {
+		cmd = append(cmd, "-benchmem")
+	}
+
+	ctr := g.Base
+	if g.Private != nil {
+		ctr = g.enablePrivateModules(ctr)
+	}
+
+	return ctr.WithExec(cmd).Stdout(ctx)
+}
+
+// GolangBenchComparison contains the result of comparing benchmarks against a baseline,
+// it serves as an intermediate type for retrieving either the comparison or the new results
+type GolangBenchComparison struct {
+	// +private
+	Ctr *dagger.Container
+	// +private
+	Threshold int
+}
+
+// Executes benchmarks defined within the target project and compares them against a baseline
+// using benchstat. The comparison must be retrieved through either its report or results.
+// Benchmarks are executed multiple times to ensure a statistically significant comparison
+//
+// Examples:
+//
+// # Fail if any benchmark regresses by more than 15%
+// $ dagger call bench-compare --baseline bench.txt --threshold 15 report
+//
+// # Save the new benchmark results as the next baseline
+// $ dagger call bench-compare --baseline bench.txt results export --path bench.txt
+func (g *Golang) BenchCompare(
+	ctx context.Context,
+	// the results of a previous benchmark run, generated by go test -bench
+	// +required
+	baseline *dagger.File,
+	// the number of times each benchmark is executed, benchstat requires at least 6 runs
+	// for a statistically significant comparison
+	// +optional
+	// +default=6
+	count int,
+	// print memory allocation statistics for benchmarks
+	// +optional
+	// +default=true
+	memory bool,
+	// the maximum percentage that any benchmark can regress by before failing
+	// +optional
+	// +default=10
+	threshold int,
+	// the time.Duration each benchmark should run for
+	// +optional
+	// +default="1s"
+	benchtime string,
+) (*GolangBenchComparison, error) {
+	ctr := g.Base
+	if g.Private != nil {
+		ctr = g.enablePrivateModules(ctr)
+	}
+
+	if _, err := ctr.WithExec([]string{"which", "benchstat"}).Sync(ctx); err != nil {
+		ctr = ctr.WithExec([]string{"go", "install", "golang.org/x/perf/cmd/benchstat@latest"})
+	}
+
+	cmd := withModFlag([]string{"go", "test", "-bench=.", "-benchtime", benchtime, "-count", strconv.Itoa(count), "-run=^#", "./..."}, g.Mod)
+	if memory {
+		cmd = append(cmd, "-benchmem")
+	}
+
+	ctr = ctr.
+		WithFile(benchBaseline, baseline).
+		WithExec(cmd, dagger.ContainerWithExecOpts{RedirectStdout: benchResults})
+
+	return &GolangBenchComparison{Ctr: ctr, Threshold: threshold}, nil
+}
+
+// Returns a benchstat report comparing the new benchmark results against the baseline. Fails
+// if any benchmark has regressed beyond the threshold, with each regression reported
+func (c *GolangBenchComparison) Report(ctx context.Context) (string, error) {
+	report, err := c.Ctr.WithExec([]string{"benchstat", benchBaseline, benchResults}).Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := c.Ctr.WithExec([]string{"benchstat", "-format", "csv", benchBaseline, benchResults}).Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	regressions, err := benchRegressions(out, float64(c.Threshold))
+	if err != nil {
+		return "", err
+	}
+
+	if len(regressions) > 0 {
+		return "", fmt.Errorf("benchmarks regressed beyond the %d%% threshold:\n%s\n\n%s",
+			c.Threshold, strings.Join(regressions, "\n"), report)
+	}
+
+	return report, nil
+}
+
+// Returns the new benchmark results, which can be saved as the next baseline
+func (c *GolangBenchComparison) Results() *dagger.File {
+	return c.Ctr.File(benchResults)
+}
+
+// Parses the CSV output of benchstat, identifying any benchmarks that have regressed beyond
+// the threshold. Only statistically significant changes are reported by benchstat, all other
+// changes are marked with a ~. Every unit reported by benchstat (sec/op, B/op and allocs/op)
+// is considered worse if it increases
+func benchRegressions(out string, threshold float64) ([]string, error) {
+	r := csv.NewReader(strings.NewReader(out))
+	r.FieldsPerRecord = -1
+
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse benchstat report: %w", err)
+	}
+
+	var unit string
+	var regressions []string
+	for _, record := range records {
+		if len(record) < 6 {
+			continue
+		}
+
+		// Each section is preceded by a header row defining the unit being compared
+		if record[0] == "" {
+			unit = record[1]
+			continue
+		}
+
+		if record[0] == "geomean" {
+			continue
+		}
+
+		delta, found := strings.CutSuffix(record[5], "%")
+		if !found {
+			continue
+		}
+
+		change, err := strconv.ParseFloat(delta, 64)
+		if err != nil {
+			continue
+		}
+
+		if change > threshold {
+			regressions = append(regressions, fmt.Sprintf("%s: %s +%.2f%%", record[0], unit, change))
+		}
+	}
+
+	return regressions, nil
+}
+
+// Scans the target project for vulnerabilities using govulncheck
+func (g *Golang) Vulncheck(ctx context.Context) (string, error) {
+	if g.Version == "1.17" {
+		return "", fmt.Errorf("govulncheck supports go versions 1.18 and higher")
+	}
+
+	ctr := g.Base
+	if _, err := ctr.WithExec([]string{"govulncheck", "--version"}).Sync(ctx); err != nil {
+		tag, err := dag.Github().GetLatestRelease("golang/vuln").Tag(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		ctr = ctr.WithExec([]string{"go", "install", "golang.org/x/vuln/cmd/govulncheck@" + tag})
+	}
+
+	if g.Private != nil {
+		ctr = g.enablePrivateModules(ctr)
+	}
+
+	return ctr.
+		WithExec([]string{"govulncheck", "./..."}).
+		Stdout(ctx)
+}
+
+// A module dependency, as reported by go list -m -json
+type goModule struct {
+	Path     string    `json:"Path"`
+	Version  string    `json:"Version,omitempty"`
+	Main     bool      `json:"Main,omitempty"`
+	Indirect bool      `json:"Indirect,omitempty"`
+	Replace  *goModule `json:"Replace,omitempty"`
+}
+
+// Lists all module dependencies of the target project, including their versions, as JSON.
+// Any module replacements are included, and the main module is excluded. Useful for
+// generating an SBOM or reviewing the licenses of dependencies
+func (g *Golang) ModGraph(ctx context.Context) (string, error) {
+	ctr := g.Base
+	if g.Private != nil {
+		ctr = g.enablePrivateModules(ctr)
+	}
+
+	out, err := ctr.WithExec([]string{"go", "list", "-m", "-json", "all"}).Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	modules := []goModule{}
+	dec := json.NewDecoder(strings.NewReader(out))
+	for dec.More() {
+		var mod goModule
+		if err := dec.Decode(&mod); err != nil {
+			return "", err
+		}
+
+		if !mod.Main {
+			modules = append(modules, mod)
+		}
+	}
+
+	data, err := json.MarshalIndent(modules, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// A breakdown of the requirements within a go.mod file
+type dependencyReport struct {
+	Direct      []goModule `json:"direct"`
+	TestOnly    []goModule `json:"testOnly"`
+	Indirect    []goModule `json:"indirect"`
+	Promote     []goModule `json:"promote"`
+	NotImported []goModule `json:"notImported"`
+}
+
+// Classifies the requirements within the go.mod file of the target project as either direct,
+// test-only or indirect dependencies, returning the breakdown as JSON. Requirements marked as
+// indirect, but imported directly by the project, are flagged for promotion. Direct requirements
+// that are not imported by any package are also flagged, as they may need removing
+func (g *Golang) Dependencies(ctx context.Context) (string, error) {
+	ctr := g.Base
+	if g.Private != nil {
+		ctr = g.enablePrivateModules(ctr)
+	}
+
+	mod, err := ctr.File(path.Join(g.WorkDir, goMod)).Contents(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := modfile.Parse(goMod, []byte(mod), nil)
+	if err != nil {
+		return "", err
+	}
+
+	imports, err := ctr.WithExec([]string{"go", "list", "-f", "{{range .Imports}}{{.}}\n{{end}}", "./..."}).Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	testImports, err := ctr.WithExec([]string{
+		"go", "list", "-f", "{{range .TestImports}}{{.}}\n{{end}}{{range .XTestImports}}{{.}}\n{{end}}", "./...",
+	}).Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var paths []string
+	for _, req := range f.Require {
+		paths = append(paths, req.Mod.Path)
+	}
+
+	imported := importedModules(imports, paths)
+	testImported := importedModules(testImports, paths)
+
+	report := dependencyReport{
+		Direct:      []goModule{},
+		TestOnly:    []goModule{},
+		Indirect:    []goModule{},
+		Promote:     []goModule{},
+		NotImported: []goModule{},
+	}
+
+	for _, req := range f.Require {
+		dep := goModule{Path: req.Mod.Path, Version: req.Mod.Version, Indirect: req.Indirect}
+
+		switch {
+		case imported[dep.Path]:
+			report.Direct = append(report.Direct, dep)
+		case testImported[dep.Path]:
+			report.TestOnly = append(report.TestOnly, dep)
+		default:
+			report.Indirect = append(report.Indirect, dep)
+		}
+
+		if req.Indirect && (imported[dep.Path] || testImported[dep.Path]) {
+			report.Promote = append(report.Promote, dep)
+		}
+
+		if !req.Indirect && !imported[dep.Path] && !testImported[dep.Path] {
+			report.NotImported = append(report.NotImported, dep)
+		}
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// Resolves the modules that provide each of the imported packages, by matching
+// each package against the longest module path prefix
+func importedModules(imports string, modules []string) map[string]bool {
+	imported := map[string]bool{}
+	for _, pkg := range strings.Fields(imports) {
+		var match string
+		for _, mod := range modules {
+			if (pkg == mod || strings.HasPrefix(pkg, mod+"/")) && len(mod) > len(match) {
+				match = mod
+			}
+		}
+
+		if match != "" {
+			imported[match] = true
+		}
+	}
+	return imported
+}
+
+// Explains why a package or module is needed by the target project, printing the shortest
+// path of imports from the main module using go mod why
+func (g *Golang) ModWhy(
+	ctx context.Context,
+	// the import path of the package (or module) to explain
+	// +required
+	pkg string,
+	// treat the provided path as a module rather than a package
+	// +optional
+	module bool,
+) (string, error) {
+	cmd := []string{"go", "mod", "why"}
+	if module {
+		cmd = append(cmd, "-m")
+	}
+	cmd = append(cmd, pkg)
+
+	ctr := g.Base
+	if g.Private != nil {
+		ctr = g.enablePrivateModules(ctr)
+	}
+
+	return ctr.WithExec(cmd).Stdout(ctx)
+}
+
+// Lint the target project using golangci-lint
+//
+// Examples:
+//
+// # Only report issues introduced since the main branch
+// $ dagger call --src . lint --new-from-rev main
+func (g *Golang) Lint(
+	ctx context.Context,
+	// the type of report that should be generated
+	// +optional
+	// +default="colored-line-number"
+	format string,
+	// a list of additional linters to enable
+	// +optional
+	enable []string,
+	// a list of linters to disable
+	// +optional
+	disable []string,
+	// only report issues introduced since this git revision, e.g. main. The source
+	// directory must include its .git directory
+	// +optional
+	newFromRev string,
+) (string, error) {
+	ctr, cmd, err := g.linter(ctx, format, enable, disable, newFromRev)
+	if err != nil {
+		return "", err
+	}
+
+	return ctr.WithExec(cmd).Stdout(ctx)
+}
+
+// Lint the target project using golangci-lint, generating a SARIF report suitable for
+// uploading to GitHub code scanning. Any reported issues are captured within the report
+// rather than failing the lint
+//
+// Examples:
+//
+// # Generate a SARIF report for GitHub code scanning
+// $ dagger call --src . lint-sarif export --path golangci-lint.sarif
+func (g *Golang) LintSarif(
+	ctx context.Context,
+	// a list of additional linters to enable
+	// +optional
+	enable []string,
+	// a list of linters to disable
+	// +optional
+	disable []string,
+	// only report issues introduced since this git revision, e.g. main. The source
+	// directory must include its .git directory
+	// +optional
+	newFromRev string,
+) (*dagger.File, error) {
+	ctr, cmd, err := g.linter(ctx, "sarif", enable, disable, newFromRev)
+	if err != nil {
+		return nil, err
+	}
+
+	ctr = ctr.WithExec(cmd, dagger.ContainerWithExecOpts{
+		RedirectStdout: lintSarifReport,
+		Expect:         dagger.ReturnTypeAny,
+	})
+
+	code, err := ctr.ExitCode(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// golangci-lint exits with a code of 1 when issues are found
+	if code > 1 {
+		stderr, err := ctr.Stderr(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("golangci-lint failed with exit code %d: %s", code, stderr)
+	}
+
+	return ctr.File(lintSarifReport), nil
+}
+
+// Prepares a container with golangci-lint installed, returning the command for linting
+// the target project in the given format
+func (g *Golang) linter(
+	ctx context.Context,
+	format string,
+	enable []string,
+	disable []string,
+	newFromRev string,
+) (*dagger.Container, []string, error) {
+	ctr := g.Base
+	var baseline string
+	if newFromRev != "" {
+		var err error
+		if ctr, baseline, err = withGitRevision(ctx, ctr, newFromRev); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if _, err := ctr.WithExec([]string{"golangci-lint", "version"}).Sync(ctx); err != nil {
+		tag, err := dag.Github().GetLatestRelease("golangci/golangci-lint").Tag(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// Install using the recommended approach: https://golangci-lint.run/welcome/install/
+		cmd := []string{
+			"curl",
+			"-sSfL",
+			"https://raw.githubusercontent.com/golangci/golangci-lint/master/install.sh",
+			"|",
+			"sh",
+			"-s",
+			"--",
+			"-b",
+			"$(go env GOPATH)/bin",
+			tag,
+		}
+		ctr = ctr.WithExec([]string{"bash", "-c", strings.Join(cmd, " ")})
+	}
+
+	cmd := []string{
+		"golangci-lint",
+		"run",
+		"--timeout",
+		"5m",
+		"--go",
+		g.Version,
+		"--out-format",
+		format,
+	}
+
+	if len(enable) > 0 {
+		cmd = append(cmd, "--enable", strings.Join(enable, ","))
+	}
+
+	if len(disable) > 0 {
+		cmd = append(cmd, "--disable", strings.Join(disable, ","))
+	}
+
+	if baseline != "" {
+		cmd = append(cmd, "--new-from-rev", baseline)
+	}
+
+	if g.Private != nil {
+		ctr = g.enablePrivateModules(ctr)
+	}
+
+	return ctr, cmd, nil
+}
+
+// Ensures a git revision can be resolved within the project, fetching any missing
+// history from the remote if the repository is a shallow clone. The commit the
+// revision resolves to is returned
+func withGitRevision(ctx context.Context, ctr *dagger.Container, rev string) (*dagger.Container, string, error) {
+	if _, err := ctr.WithExec([]string{"git", "rev-parse", "--git-dir"}).Sync(ctx); err != nil {
+		return nil, "", fmt.Errorf("git history is unavailable, ensure the .git directory is included within the source directory: %w", err)
+	}
+
+	shallow, err := ctr.WithExec([]string{"git", "rev-parse", "--is-shallow-repository"}).Stdout(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if strings.TrimSpace(shallow) == "true" {
+		ctr = ctr.WithExec([]string{"git", "fetch", "--unshallow", "origin"})
+	}
+
+	commit, err := ctr.WithExec([]string{"git", "rev-parse", "--verify", "--quiet", rev + "^{commit}"}).Stdout(ctx)
+	if err != nil {
+		// The revision may only exist on the remote, such as a branch that was never checked out
+		ctr = ctr.WithExec([]string{"git", "fetch", "origin", rev})
+		if commit, err = ctr.WithExec([]string{"git", "rev-parse", "--verify", "--quiet", "FETCH_HEAD^{commit}"}).Stdout(ctx); err != nil {
+			return nil, "", fmt.Errorf("git revision %s could not be resolved: %w", rev, err)
+		}
+	}
+
+	return ctr, strings.TrimSpace(commit), nil
+}
+
+// Analyzes the target project using staticcheck, independently of golangci-lint. Any
+// staticcheck.conf configuration files within the project are respected. Fails if any
+// issues are found
+func (g *Golang) Staticcheck(
+	ctx context.Context,
+	// a comma separated list of checks to enable, supporting both categories
+	// and individual checks (e.g. SA,ST,-ST1000)
+	// +optional
+	checks string,
+) (string, error) {
+	ctr := g.Base
+	if _, err := ctr.WithExec([]string{"staticcheck", "-version"}).Sync(ctx); err != nil {
+		tag, err := dag.Github().GetLatestRelease("dominikh/go-tools").Tag(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		ctr = ctr.WithExec([]string{"go", "install", "honnef.co/go/tools/cmd/staticcheck@" + tag})
+	}
+
+	cmd := []string{"staticcheck"}
+	if checks != "" {
+		cmd = append(cmd, "-checks", checks)
+	}
+	cmd = append(cmd, "./...")
+
+	if g.Private != nil {
+		ctr = g.enablePrivateModules(ctr)
+	}
+
+	return ctr.WithExec(cmd).Stdout(ctx)
+}
+
+// Format the source code within a target project using gofumpt. Formatted code must be
+// copied back onto the host.`
+func (g *Golang) Format(ctx context.Context) (*dagger.Directory, error) {
+	ctr, err := g.withGofumpt(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := []string{"gofumpt", "-w", "-d", "."}
+
+	return ctr.WithExec(cmd).Directory(g.WorkDir), nil
+}
+
+func (g *Golang) withGofumpt(ctx context.Context) (*dagger.Container, error) {
+	ctr := g.Base
+	if _, err := ctr.WithExec([]string{"gofumpt", "-version"}).Sync(ctx); err != nil {
+		tag, err := dag.Github().GetLatestRelease("mvdan/gofumpt").Tag(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		ctr = ctr.WithExec([]string{"go", "install", "mvdan.cc/gofumpt@" + tag})
+	}
+
+	return ctr, nil
+}
+
+// Checks the format of the source code within a target project using gofumpt. Fails
+// if any files need formatting, listing each of them
+func (g *Golang) FormatCheck(ctx context.Context) (string, error) {
+	ctr, err := g.withGofumpt(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := ctr.WithExec([]string{"gofumpt", "-l", "."}).Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if unformatted := strings.TrimSpace(out); unformatted != "" {
+		return "", fmt.Errorf("the following files are not formatted:\n%s", unformatted)
+	}
+
+	return "", nil
+}
+
+// Examines the source code within the target project using go vet, reporting
+// any suspicious constructs
+func (g *Golang) Vet(ctx context.Context) (string, error) {
+	ctr := g.Base
+	if g.Private != nil {
+		ctr = g.enablePrivateModules(ctr)
+	}
+
+	return ctr.WithExec(withModFlag([]string{"go", "vet", "./..."}, g.Mod)).Stdout(ctx)
+}
+
+// Runs the standard quality gates against the target project in sequence: a format check
+// (without rewriting any code), vet, lint, test and vulncheck. Each gate runs regardless of
+// any previous failure, with a combined report returned. Fails if any gate fails
+func (g *Golang) Check(
+	ctx context.Context,
+	// skip checking the format of the source code
+	// +optional
+	skipFormat bool,
+	// skip linting the source code
+	// +optional
+	skipLint bool,
+	// skip executing the tests
+	// +optional
+	skipTest bool,
+	// skip examining the source code with go vet
+	// +optional
+	skipVet bool,
+	// skip scanning for vulnerabilities
+	// +optional
+	skipVulncheck bool,
+) (string, error) {
+	gates := []struct {
+		name string
+		skip bool
+		run  func(context.Context) (string, error)
+	}{
+		{name: "format", skip: skipFormat, run: g.FormatCheck},
+		{name: "vet", skip: skipVet, run: g.Vet},
+		{name: "lint", skip: skipLint, run: func(ctx context.Context) (string, error) {
+			return g.Lint(ctx, "line-number", nil, nil, "")
+		}},
+		{name: "test", skip: skipTest, run: func(ctx context.Context) (string, error) {
+			return g.Test(ctx, true, true, "", "", false, false, nil)
+		}},
+		{name: "vulncheck", skip: skipVulncheck, run: g.Vulncheck},
+	}
+
+	var report strings.Builder
+	var failed []string
+	for _, gate := range gates {
+		if gate.skip {
+			fmt.Fprintf(&report, "=== %s: SKIPPED\n\n", gate.name)
+			continue
+		}
+
+		out, err := gate.run(ctx)
+		if err != nil {
+			failed = append(failed, gate.name)
+			fmt.Fprintf(&report, "=== %s: FAIL\n%s\n\n", gate.name, err)
+			continue
+		}
+		fmt.Fprintf(&report, "=== %s: PASS\n%s\n", gate.name, out)
+	}
+
+	if len(failed) > 0 {
+		return "", fmt.Errorf("checks failed: %s\n\n%s", strings.Join(failed, ", "), report.String())
+	}
 
-	return ctr.WithExec(cmd).Directory(goWorkDir), nil
+	return report.String(), nil
 }
@@ -4,9 +4,13 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 
 	"dagger/helm-oci/internal/dagger"
@@ -16,16 +20,36 @@ import (
 )
 
 const (
-	HelmGithubRepo       = "helm/helm"
-	HelmBaseImage        = "alpine/helm"
-	HelmRepositoryConfig = "/root/.config/helm/registry/config.json"
-	HelmWorkDir          = "/work"
+	HelmGithubRepo         = "helm/helm"
+	HelmBaseImage          = "alpine/helm"
+	HelmRepositoryConfig   = "/root/.config/helm/registry/config.json"
+	HelmWorkDir            = "/work"
+	HelmPostRenderer       = "/tmp/helm/post-renderer"
+	HelmPackageDir         = "/tmp/helm/package"
+	HelmUnittestGithubRepo = "helm-unittest/helm-unittest"
+	CosignBaseImage        = "cgr.dev/chainguard/cosign"
+	CosignDockerConfig     = "/tmp/cosign/config.json"
+	OrasGithubRepo         = "oras-project/oras"
+	OrasBaseImage          = "ghcr.io/oras-project/oras"
+	OrasRegistryConfig     = "/tmp/oras/config.json"
 )
 
 // Helm OCI dagger module
 type HelmOci struct {
 	// +private
 	Base *dagger.Container
+	// +private
+	DependencyAuths []HelmRegistryAuth
+}
+
+// Credentials for authenticating with an OCI registry hosting chart dependencies
+type HelmRegistryAuth struct {
+	// +private
+	Registry string
+	// +private
+	Username string
+	// +private
+	Password *dagger.Secret
 }
 
 // Initializes the Helm OCI dagger module
@@ -51,6 +75,120 @@ func New(
 	return &HelmOci{Base: base}, err
 }
 
+// Authenticates with an OCI registry when downloading chart dependencies declared with an
+// oci:// repository, such as the subcharts of an umbrella chart. If a chart does not vendor
+// its dependencies within a charts directory, OCI dependencies are downloaded through helm
+// dependency build before it is packaged, linted or templated. Can be called multiple times
+// to authenticate with multiple registries
+//
+// Examples:
+//
+// # Package an umbrella chart whose subcharts live within a private OCI registry
+// $ dagger call with-dependency-auth --registry ghcr.io --username user --password env:TOKEN package --dir .
+func (m *HelmOci) WithDependencyAuth(
+	// the address of the OCI registry hosting the chart dependencies
+	// +required
+	registry string,
+	// the username for authenticating with the registry
+	// +required
+	username string,
+	// the password for authenticating with the registry
+	// +required
+	password *dagger.Secret,
+) (*HelmOci, error) {
+	host, err := extractRegistryHost(registry)
+	if err != nil {
+		return nil, err
+	}
+
+	m.DependencyAuths = append(m.DependencyAuths, HelmRegistryAuth{
+		Registry: host,
+		Username: username,
+		Password: password,
+	})
+	return m, nil
+}
+
+// Downloads any dependencies declared within the Chart.yaml file into the charts directory
+// using helm dependency build, authenticating with any configured OCI registries. Only OCI
+// and local (file://) dependencies are supported, as any other remote repository would first
+// need to be added through helm repo add. Dependencies are only built if an OCI dependency
+// has not been vendored within the charts directory
+func (m *HelmOci) withDependencies(
+	ctx context.Context,
+	ctr *dagger.Container,
+	dir *dagger.Directory,
+	metadata *chart.Metadata,
+) (*dagger.Container, error) {
+	var oci []string
+	for _, dep := range metadata.Dependencies {
+		switch {
+		case strings.HasPrefix(dep.Repository, "oci://"):
+			oci = append(oci, dep.Name)
+		case dep.Repository == "" || strings.HasPrefix(dep.Repository, "file://"):
+		default:
+			return nil, fmt.Errorf("dependency %s uses an unsupported repository %s, only oci:// and file:// repositories are supported",
+				dep.Name, dep.Repository)
+		}
+	}
+
+	if len(oci) == 0 {
+		return ctr, nil
+	}
+
+	vendored, err := vendoredCharts(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	missing := slices.ContainsFunc(oci, func(name string) bool {
+		return !slices.ContainsFunc(vendored, func(entry string) bool {
+			return isVendoredChart(entry, name)
+		})
+	})
+
+	if !missing {
+		return ctr, nil
+	}
+
+	if len(m.DependencyAuths) > 0 {
+		login := dag.OciLogin()
+		for _, auth := range m.DependencyAuths {
+			login = login.WithAuth(auth.Registry, auth.Username, auth.Password)
+		}
+		ctr = ctr.WithMountedSecret(HelmRepositoryConfig, login.AsSecret(dagger.OciLoginAsSecretOpts{}))
+	}
+
+	return ctr.WithExec([]string{"helm", "dependency", "build", "."}), nil
+}
+
+// Lists the entries within the charts directory of a chart, which is empty if the
+// directory does not exist
+func vendoredCharts(ctx context.Context, dir *dagger.Directory) ([]string, error) {
+	entries, err := dir.Entries(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !slices.Contains(entries, "charts") && !slices.Contains(entries, "charts/") {
+		return nil, nil
+	}
+
+	return dir.Directory("charts").Entries(ctx)
+}
+
+// Checks if an entry within the charts directory is a vendored copy of the named chart,
+// either as an unpacked directory or a versioned chart archive (<name>-<version>.tgz)
+func isVendoredChart(entry, name string) bool {
+	entry = strings.TrimSuffix(entry, "/")
+	if entry == name {
+		return true
+	}
+
+	version, ok := strings.CutPrefix(entry, name+"-")
+	return ok && strings.HasSuffix(version, ".tgz") && version[0] >= '0' && version[0] <= '9'
+}
+
 func defaultImage(ctx context.Context) (*dagger.Container, error) {
 	tag, err := dag.Github().GetLatestRelease(HelmGithubRepo).Tag(ctx)
 	if err != nil {
@@ -126,9 +264,16 @@ func (m *HelmOci) Package(
 		ver = version
 	}
 
-	return m.Base.
+	ctr := m.Base.
 		WithMountedDirectory(HelmWorkDir, dir).
-		WithWorkdir(HelmWorkDir).
+		WithWorkdir(HelmWorkDir)
+
+	ctr, err = m.withDependencies(ctx, ctr, dir, chart)
+	if err != nil {
+		return nil, err
+	}
+
+	return ctr.
 		WithExec([]string{
 			"helm",
 			"package",
@@ -141,6 +286,144 @@ func (m *HelmOci) Package(
 		File(fmt.Sprintf("%s-%s.tgz", chart.Name, ver)), nil
 }
 
+// Packages a chart in the same way as Package, returning a directory containing both the
+// chart archive file and a (<name>-<version>.tgz.sha256) file with its SHA256 digest. The
+// digest file is compatible with `sha256sum -c`
+//
+// Examples:
+//
+// # Package a chart alongside its digest
+// $ dagger call package-with-digest --dir . export --path dist
+func (m *HelmOci) PackageWithDigest(
+	ctx context.Context,
+	// a path to the directory containing the Chart.yaml file
+	// +required
+	dir *dagger.Directory,
+	// override the semantic version of the application this chart deploys
+	// +optional
+	appVersion string,
+	// override the semantic version of the chart
+	// +optional
+	version string,
+) (*dagger.Directory, error) {
+	pkg, err := m.Package(ctx, dir, appVersion, version)
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := pkg.Name(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.Base.
+		WithWorkdir(HelmPackageDir).
+		WithFile(name, pkg).
+		WithExec([]string{"sh", "-c", `sha256sum "$1" > "$1.sha256"`, "sh", name}).
+		Directory(HelmPackageDir), nil
+}
+
+// Computes the SHA256 digest of a packaged chart, in (sha256:<hex>) format. The digest
+// matches that of the chart layer once the same package is pushed to an OCI registry,
+// making it useful for checking whether a specific package has already been published.
+// It cannot detect whether a chart has changed between packages, as helm package stamps
+// each file within the archive with the time of packaging, so repackaging an unchanged
+// chart produces a different digest
+//
+// Examples:
+//
+// # Compute the digest of a packaged chart
+// $ dagger call digest --pkg example-0.1.0.tgz
+func (m *HelmOci) Digest(
+	ctx context.Context,
+	// the packaged helm chart
+	// +required
+	pkg *dagger.File,
+) (string, error) {
+	out, err := m.Base.
+		WithMountedFile("/tmp/helm/chart.tgz", pkg).
+		WithExec([]string{"sha256sum", "/tmp/helm/chart.tgz"}).
+		Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	fields := strings.Fields(out)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("failed to compute digest of packaged chart")
+	}
+
+	return "sha256:" + fields[0], nil
+}
+
+// Packages every chart discovered within a directory into a versioned chart archive file,
+// using the metadata defined within each Chart.yaml file. Any directory containing a Chart.yaml
+// file is treated as a chart, excluding subcharts nested within another chart. A directory is
+// returned containing all of the packaged charts
+func (m *HelmOci) PackageAll(
+	ctx context.Context,
+	// a path to a directory containing multiple charts (e.g. charts/*)
+	// +required
+	dir *dagger.Directory,
+) (*dagger.Directory, error) {
+	chartDirs, err := discoverCharts(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(chartDirs) == 0 {
+		return nil, fmt.Errorf("no charts found within directory")
+	}
+
+	pkgs := dag.Directory()
+	for _, chartDir := range chartDirs {
+		pkg, err := m.Package(ctx, dir.Directory(chartDir), "", "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to package chart %s: %w", chartDir, err)
+		}
+
+		name, err := pkg.Name(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		pkgs = pkgs.WithFile(name, pkg)
+	}
+
+	return pkgs, nil
+}
+
+func discoverCharts(ctx context.Context, dir *dagger.Directory) ([]string, error) {
+	manifests, err := dir.Glob(ctx, "**/Chart.yaml")
+	if err != nil {
+		return nil, err
+	}
+
+	var chartDirs []string
+	for _, manifest := range manifests {
+		chartDirs = append(chartDirs, filepath.Dir(manifest))
+	}
+	sort.Strings(chartDirs)
+
+	// Exclude any subcharts nested within the charts directory of a parent chart
+	var charts []string
+	for _, chartDir := range chartDirs {
+		nested := false
+		for _, parent := range charts {
+			if parent == "." || strings.HasPrefix(chartDir, parent+"/") {
+				nested = true
+				break
+			}
+		}
+
+		if !nested {
+			charts = append(charts, chartDir)
+		}
+	}
+
+	return charts, nil
+}
+
 func resolveChartMetadata(ctx context.Context, dir *dagger.Directory) (*chart.Metadata, error) {
 	manifest, err := dir.File("Chart.yaml").Contents(ctx)
 	if err != nil {
@@ -155,7 +438,8 @@ func resolveChartMetadata(ctx context.Context, dir *dagger.Directory) (*chart.Me
 	return metadata, nil
 }
 
-// Push a packaged chart to a chart registry
+// Push a packaged chart to a chart registry. The pushed chart can optionally be signed
+// with cosign, using either a private key or keyless signing through an OIDC identity token
 func (m *HelmOci) Push(
 	ctx context.Context,
 	// the packaged helm chart
@@ -170,6 +454,25 @@ func (m *HelmOci) Push(
 	// the password for authenticating with the registry
 	// +optional
 	password *dagger.Secret,
+	// use insecure HTTP connections when pushing the chart, typically for a
+	// local registry (e.g. localhost:5000)
+	// +optional
+	plainHttp bool,
+	// skip TLS certificate verification when pushing the chart, typically for
+	// a registry using a self-signed certificate
+	// +optional
+	insecureSkipTlsVerify bool,
+	// a cosign private key for signing the pushed chart. The reference of the generated
+	// signature is included within the output
+	// +optional
+	cosignKey *dagger.Secret,
+	// the password for decrypting the cosign private key
+	// +optional
+	cosignPassword *dagger.Secret,
+	// an OIDC identity token for keyless signing of the pushed chart with cosign. Ignored
+	// if a cosign private key is provided
+	// +optional
+	cosignIdentityToken *dagger.Secret,
 ) (string, error) {
 	regHost, err := extractRegistryHost(registry)
 	if err != nil {
@@ -192,10 +495,88 @@ func (m *HelmOci) Push(
 		return "", err
 	}
 
-	return ctr.
+	cmd := []string{"helm", "push", tgzName, reg}
+	if plainHttp {
+		cmd = append(cmd, "--plain-http")
+	}
+
+	if insecureSkipTlsVerify {
+		cmd = append(cmd, "--insecure-skip-tls-verify")
+	}
+
+	out, err := ctr.
 		WithMountedFile(tgzName, pkg).
-		WithExec([]string{"helm", "push", tgzName, reg}).
+		WithExec(cmd).
 		Stderr(ctx)
+	if err != nil || (cosignKey == nil && cosignIdentityToken == nil) {
+		return out, err
+	}
+
+	ref, err := pushedDigestRef(out)
+	if err != nil {
+		return "", err
+	}
+
+	cosign := dag.Container().From(CosignBaseImage)
+	if username != "" && password != nil {
+		auth := dag.OciLogin().WithAuth(regHost, username, password).AsSecret(dagger.OciLoginAsSecretOpts{})
+		cosign = cosign.
+			WithEnvVariable("DOCKER_CONFIG", filepath.Dir(CosignDockerConfig)).
+			WithMountedSecret(CosignDockerConfig, auth, dagger.ContainerWithMountedSecretOpts{Owner: "nonroot"})
+	}
+
+	signCmd := []string{"sign", "--yes"}
+	if cosignKey != nil {
+		cosign = cosign.WithSecretVariable("COSIGN_KEY", cosignKey)
+		signCmd = append(signCmd, "--key", "env://COSIGN_KEY")
+
+		if cosignPassword != nil {
+			cosign = cosign.WithSecretVariable("COSIGN_PASSWORD", cosignPassword)
+		}
+	} else {
+		cosign = cosign.WithSecretVariable("SIGSTORE_ID_TOKEN", cosignIdentityToken)
+	}
+
+	if plainHttp {
+		signCmd = append(signCmd, "--allow-http-registry")
+	}
+
+	if insecureSkipTlsVerify {
+		signCmd = append(signCmd, "--allow-insecure-registry")
+	}
+	signCmd = append(signCmd, ref)
+
+	if _, err := cosign.WithExec(signCmd, dagger.ContainerWithExecOpts{UseEntrypoint: true}).Sync(ctx); err != nil {
+		return "", err
+	}
+
+	repo, digest, _ := strings.Cut(ref, "@")
+	return fmt.Sprintf("%sSigned: %s:%s.sig\n", out, repo, strings.Replace(digest, ":", "-", 1)), nil
+}
+
+// Extracts a digest reference to the pushed chart from the output of helm push
+func pushedDigestRef(out string) (string, error) {
+	var pushed, digest string
+	for _, line := range strings.Split(out, "\n") {
+		if ref, found := strings.CutPrefix(line, "Pushed:"); found {
+			pushed = strings.TrimSpace(ref)
+		}
+
+		if dgst, found := strings.CutPrefix(line, "Digest:"); found {
+			digest = strings.TrimSpace(dgst)
+		}
+	}
+
+	if pushed == "" || digest == "" {
+		return "", fmt.Errorf("failed to extract digest of pushed chart from output:\n%s", out)
+	}
+
+	// Replace the tag with the digest
+	if idx := strings.LastIndex(pushed, ":"); idx > strings.LastIndex(pushed, "/") {
+		pushed = pushed[:idx]
+	}
+
+	return pushed + "@" + digest, nil
 }
 
 func extractRegistryHost(registry string) (string, error) {
@@ -207,6 +588,98 @@ func extractRegistryHost(registry string) (string, error) {
 	return reg[:idx], nil
 }
 
+// Lists all tags (chart versions) published to a chart repository within an OCI registry.
+// Useful for checking whether a chart version already exists before pushing. An empty
+// list is returned if the chart has never been published
+//
+// Examples:
+//
+// # List all published versions of a chart
+// $ dagger call tags --registry ghcr.io/purpleclay/charts --chart my-chart
+func (m *HelmOci) Tags(
+	ctx context.Context,
+	// the OCI registry containing the chart, should include full path without chart name
+	// +required
+	registry string,
+	// the name of the chart
+	// +required
+	chart string,
+	// the username for authenticating with the registry
+	// +optional
+	username string,
+	// the password for authenticating with the registry
+	// +optional
+	password *dagger.Secret,
+	// use insecure HTTP connections when querying the registry, typically for a
+	// local registry (e.g. localhost:5000)
+	// +optional
+	plainHttp bool,
+	// skip TLS certificate verification when querying the registry, typically for
+	// a registry using a self-signed certificate
+	// +optional
+	insecureSkipTlsVerify bool,
+) ([]string, error) {
+	regHost, err := extractRegistryHost(registry)
+	if err != nil {
+		return nil, err
+	}
+
+	tag, err := dag.Github().GetLatestRelease(OrasGithubRepo).Tag(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctr := dag.Container().
+		From(fmt.Sprintf("%s:%s", OrasBaseImage, tag)).
+		WithUser("root")
+
+	cmd := []string{"repo", "tags"}
+	if username != "" && password != nil {
+		auth := dag.OciLogin().WithAuth(regHost, username, password).AsSecret(dagger.OciLoginAsSecretOpts{})
+		ctr = ctr.WithMountedSecret(OrasRegistryConfig, auth)
+		cmd = append(cmd, "--registry-config", OrasRegistryConfig)
+	}
+
+	if plainHttp {
+		cmd = append(cmd, "--plain-http")
+	}
+
+	if insecureSkipTlsVerify {
+		cmd = append(cmd, "--insecure")
+	}
+
+	repo := fmt.Sprintf("%s/%s", strings.TrimSuffix(strings.TrimPrefix(registry, "oci://"), "/"), chart)
+	cmd = append(cmd, repo)
+
+	ctr, err = ctr.WithExec(cmd, dagger.ContainerWithExecOpts{
+		UseEntrypoint: true,
+		Expect:        dagger.ReturnTypeAny,
+	}).Sync(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	code, err := ctr.ExitCode(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if code != 0 {
+		stderr, _ := ctr.Stderr(ctx)
+		if strings.Contains(stderr, "not found") || strings.Contains(stderr, "NAME_UNKNOWN") {
+			return []string{}, nil
+		}
+		return nil, fmt.Errorf("failed to list tags for chart %s: %s", repo, stderr)
+	}
+
+	out, err := ctr.Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Fields(out), nil
+}
+
 // Packages a Helm chart and publishes it to an OCI registry. Semantic versioning for the chart
 // is obtained directly from the Chart.yaml file
 func (m *HelmOci) PackagePush(
@@ -229,13 +702,43 @@ func (m *HelmOci) PackagePush(
 	// the password for authenticating with the registry
 	// +optional
 	password *dagger.Secret,
+	// use insecure HTTP connections when pushing the chart, typically for a
+	// local registry (e.g. localhost:5000)
+	// +optional
+	plainHttp bool,
+	// skip TLS certificate verification when pushing the chart, typically for
+	// a registry using a self-signed certificate
+	// +optional
+	insecureSkipTlsVerify bool,
+	// a cosign private key for signing the pushed chart. The reference of the generated
+	// signature is included within the output
+	// +optional
+	cosignKey *dagger.Secret,
+	// the password for decrypting the cosign private key
+	// +optional
+	cosignPassword *dagger.Secret,
+	// an OIDC identity token for keyless signing of the pushed chart with cosign. Ignored
+	// if a cosign private key is provided
+	// +optional
+	cosignIdentityToken *dagger.Secret,
 ) (string, error) {
 	pkg, err := m.Package(ctx, dir, appVersion, version)
 	if err != nil {
 		return "", err
 	}
 
-	return m.Push(ctx, pkg, registry, username, password)
+	return m.Push(
+		ctx,
+		pkg,
+		registry,
+		username,
+		password,
+		plainHttp,
+		insecureSkipTlsVerify,
+		cosignKey,
+		cosignPassword,
+		cosignIdentityToken,
+	)
 }
 
 // Lints a Helm chart
@@ -261,13 +764,199 @@ func (m *HelmOci) Lint(
 		cmd = append(cmd, "--quiet")
 	}
 
-	return m.Base.
+	chart, err := resolveChartMetadata(ctx, dir)
+	if err != nil {
+		return "", err
+	}
+
+	ctr := m.Base.
+		WithMountedDirectory(HelmWorkDir, dir).
+		WithWorkdir(HelmWorkDir)
+
+	ctr, err = m.withDependencies(ctx, ctr, dir, chart)
+	if err != nil {
+		return "", err
+	}
+
+	return ctr.
+		WithExec(cmd).
+		Stdout(ctx)
+}
+
+// Runs unit tests against a Helm chart using the helm-unittest plugin. Test suites are
+// expected to be stored within the tests directory of the chart. Fails if any assertions
+// within the tests fail
+func (m *HelmOci) UnitTest(
+	ctx context.Context,
+	// a path to the directory containing the Chart.yaml file and tests directory
+	// +required
+	dir *dagger.Directory,
+	// stop running tests as soon as the first failure is detected
+	// +optional
+	failFast bool,
+	// glob paths of test files to run, relative to the chart directory
+	// +optional
+	// +default=["tests/*_test.yaml"]
+	file []string,
+	// fail on any unknown fields within the test suites
+	// +optional
+	strict bool,
+) (string, error) {
+	ctr := m.Base
+	if _, err := ctr.WithExec([]string{"helm", "unittest", "--help"}).Sync(ctx); err != nil {
+		tag, err := dag.Github().GetLatestRelease(HelmUnittestGithubRepo).Tag(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		ctr = ctr.WithExec([]string{
+			"helm",
+			"plugin",
+			"install",
+			fmt.Sprintf("https://github.com/%s", HelmUnittestGithubRepo),
+			"--version",
+			tag,
+		})
+	}
+
+	cmd := []string{"helm", "unittest", "."}
+	cmd = append(cmd, toFlags("--file", file)...)
+
+	if failFast {
+		cmd = append(cmd, "--failfast")
+	}
+
+	if strict {
+		cmd = append(cmd, "--strict")
+	}
+
+	return ctr.
 		WithMountedDirectory(HelmWorkDir, dir).
 		WithWorkdir(HelmWorkDir).
 		WithExec(cmd).
 		Stdout(ctx)
 }
 
+// Generates a JSON schema for a chart by inferring the type and structure of every value
+// within its values.yaml file. The generated values.schema.json file is intended as a starting
+// point for adding schema validation to an existing chart and should be refined by hand.
+// Values that are null or empty lists cannot be inferred and will accept any type
+//
+// Examples:
+//
+// # Generate a schema for an existing chart
+// $ dagger call generate-schema --dir . export --path values.schema.json
+func (m *HelmOci) GenerateSchema(
+	ctx context.Context,
+	// a path to the directory containing the values.yaml file
+	// +required
+	dir *dagger.Directory,
+) (*dagger.File, error) {
+	contents, err := dir.File("values.yaml").Contents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var values map[string]any
+	if err := yaml.Unmarshal([]byte(contents), &values); err != nil {
+		return nil, fmt.Errorf("failed to parse values.yaml: %w", err)
+	}
+
+	schema := inferSchema(values)
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+
+	out, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return dag.Directory().
+		WithNewFile("values.schema.json", string(out)+"\n", dagger.DirectoryWithNewFileOpts{Permissions: 0o644}).
+		File("values.schema.json"), nil
+}
+
+// Infers a JSON schema from a value decoded from YAML. The items of a list are
+// inferred from its first element
+func inferSchema(value any) map[string]any {
+	switch v := value.(type) {
+	case map[string]any:
+		props := map[string]any{}
+		for key, val := range v {
+			props[key] = inferSchema(val)
+		}
+		return map[string]any{"type": "object", "properties": props}
+	case []any:
+		schema := map[string]any{"type": "array"}
+		if len(v) > 0 {
+			schema["items"] = inferSchema(v[0])
+		}
+		return schema
+	case string:
+		return map[string]any{"type": "string"}
+	case bool:
+		return map[string]any{"type": "boolean"}
+	case float64:
+		if v == math.Trunc(v) {
+			return map[string]any{"type": "integer"}
+		}
+		return map[string]any{"type": "number"}
+	default:
+		return map[string]any{}
+	}
+}
+
+// Inspects a chart and prints information about it, such as its default values or README.
+// Works with either a directory containing a chart or a packaged chart archive
+//
+// Examples:
+//
+// # Show the default values of a packaged chart
+// $ dagger call show --pkg example-0.1.0.tgz --what values
+//
+// # Show the Chart.yaml of a chart directory
+// $ dagger call show --dir . --what chart
+func (m *HelmOci) Show(
+	ctx context.Context,
+	// a path to the directory containing the Chart.yaml file
+	// +optional
+	dir *dagger.Directory,
+	// a packaged chart archive
+	// +optional
+	pkg *dagger.File,
+	// the information to show about the chart (all,chart,crds,readme,values)
+	// +optional
+	// +default="all"
+	what string,
+) (string, error) {
+	switch what {
+	case "all", "chart", "crds", "readme", "values":
+	default:
+		return "", fmt.Errorf("unsupported option '%s', expected one of (all,chart,crds,readme,values)", what)
+	}
+
+	if (dir == nil) == (pkg == nil) {
+		return "", fmt.Errorf("either a chart directory or a packaged chart must be provided")
+	}
+
+	target := "."
+	ctr := m.Base.WithWorkdir(HelmWorkDir)
+	if dir != nil {
+		ctr = ctr.WithMountedDirectory(HelmWorkDir, dir)
+	} else {
+		name, err := pkg.Name(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		ctr = ctr.WithMountedFile(filepath.Join(HelmWorkDir, name), pkg)
+		target = name
+	}
+
+	return ctr.
+		WithExec([]string{"helm", "show", what, target}).
+		Stdout(ctx)
+}
+
 // Renders a chart and captures output to a YAML file. Any values that would
 // be looked up within a Kubernetes cluster are faked. When overriding values,
 // the priority will always be given to the last (right-most) provided value
@@ -276,6 +965,21 @@ func (m *HelmOci) Template(
 	// a path to the directory containing the Chart.yaml file and all templates
 	// +required
 	dir *dagger.Directory,
+	// the namespace of the release, exposed to templates through .Release.Namespace
+	// +optional
+	namespace string,
+	// an executable, such as a shell script, for modifying the rendered manifests before
+	// they are returned. The rendered manifests are passed through stdin, with the modified
+	// manifests expected on stdout. Any tools used by the executable, such as kustomize,
+	// must exist within the base image
+	// +optional
+	postRenderer *dagger.File,
+	// a list of arguments to pass to the post-renderer
+	// +optional
+	postRendererArgs []string,
+	// the name of the release, exposed to templates through .Release.Name
+	// +optional
+	releaseName string,
 	// set values on the command line (can specify multiple or separate values
 	// with commas: key1=val1,key2=val2)
 	// +optional
@@ -308,7 +1012,15 @@ func (m *HelmOci) Template(
 		return nil, err
 	}
 
-	cmd := []string{"helm", "template", "."}
+	cmd := []string{"helm", "template"}
+	if releaseName != "" {
+		cmd = append(cmd, releaseName)
+	}
+	cmd = append(cmd, ".")
+
+	if namespace != "" {
+		cmd = append(cmd, "--namespace", namespace)
+	}
 
 	cmd = append(cmd, toFlags("--values", values)...)
 	// Hand over precedence to the helm CLI directly
@@ -318,9 +1030,16 @@ func (m *HelmOci) Template(
 	cmd = append(cmd, toFlags("--set-literal", setLiteral)...)
 	cmd = append(cmd, toFlags("--set-string", setString)...)
 
-	ctr := m.Base.
-		WithMountedDirectory(HelmWorkDir, dir).
-		WithWorkdir(HelmWorkDir)
+	ctr, err := m.withDependencies(ctx, m.Base.WithMountedDirectory(HelmWorkDir, dir).WithWorkdir(HelmWorkDir), dir, chart)
+	if err != nil {
+		return nil, err
+	}
+
+	if postRenderer != nil {
+		ctr = ctr.WithFile(HelmPostRenderer, postRenderer, dagger.ContainerWithFileOpts{Permissions: 0o755})
+		cmd = append(cmd, "--post-renderer", HelmPostRenderer)
+		cmd = append(cmd, toFlags("--post-renderer-args", postRendererArgs)...)
+	}
 
 	// Ensure values files loaded externally from the chart have higher precedence
 	for i, ext := range valuesExt {
@@ -4,6 +4,7 @@ import (
 	"context"
 	"dagger/tests/internal/dagger"
 	"fmt"
+	"strings"
 
 	"github.com/andreyvit/diff"
 	"github.com/sourcegraph/conc/pool"
@@ -16,6 +17,10 @@ func (m *Tests) AllTests(ctx context.Context) error {
 
 	p.Go(m.DotEnv)
 	p.Go(m.DotEnvGitLab)
+	p.Go(m.PackageAll)
+	p.Go(m.GenerateSchema)
+	p.Go(m.Digest)
+	p.Go(m.PackageWithDigest)
 
 	return p.Wait()
 }
@@ -75,3 +80,132 @@ TEST_CHART_KUBE_VERSION=>=1.23.0
 
 	return nil
 }
+
+func (m *Tests) PackageAll(ctx context.Context) error {
+	charts := dag.CurrentModule().Source().Directory("./testdata")
+
+	pkgs, err := dag.HelmOci(dagger.HelmOciOpts{Base: dag.Container().From("alpine/helm:3.16.2")}).
+		PackageAll(charts).
+		Entries(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(pkgs) != 1 || pkgs[0] != "example-0.2.0.tgz" {
+		return fmt.Errorf("expected a single packaged chart example-0.2.0.tgz but found: %v", pkgs)
+	}
+
+	return nil
+}
+
+func (m *Tests) GenerateSchema(ctx context.Context) error {
+	values := `replicaCount: 2
+image:
+  repository: nginx
+  pullPolicy: IfNotPresent
+ingress:
+  enabled: false
+  hosts:
+    - example.local
+resources: {}
+ratio: 0.5
+`
+	chart := dag.Directory().WithNewFile("values.yaml", values)
+
+	actual, err := dag.HelmOci(dagger.HelmOciOpts{Base: dag.Container().From("alpine/helm:3.16.2")}).
+		GenerateSchema(chart).
+		Contents(ctx)
+	if err != nil {
+		return err
+	}
+
+	expected := `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "properties": {
+    "image": {
+      "properties": {
+        "pullPolicy": {
+          "type": "string"
+        },
+        "repository": {
+          "type": "string"
+        }
+      },
+      "type": "object"
+    },
+    "ingress": {
+      "properties": {
+        "enabled": {
+          "type": "boolean"
+        },
+        "hosts": {
+          "items": {
+            "type": "string"
+          },
+          "type": "array"
+        }
+      },
+      "type": "object"
+    },
+    "ratio": {
+      "type": "number"
+    },
+    "replicaCount": {
+      "type": "integer"
+    },
+    "resources": {
+      "properties": {},
+      "type": "object"
+    }
+  },
+  "type": "object"
+}
+`
+	if actual != expected {
+		return fmt.Errorf("generated schema does not match:\n%v",
+			diff.LineDiff(expected, actual))
+	}
+
+	return nil
+}
+
+func (m *Tests) Digest(ctx context.Context) error {
+	pkg := dag.Directory().WithNewFile("example-0.1.0.tgz", "hello\n").File("example-0.1.0.tgz")
+
+	actual, err := dag.HelmOci(dagger.HelmOciOpts{Base: dag.Container().From("alpine/helm:3.16.2")}).
+		Digest(ctx, pkg)
+	if err != nil {
+		return err
+	}
+
+	expected := "sha256:5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03"
+	if actual != expected {
+		return fmt.Errorf("expected digest %s but found %s", expected, actual)
+	}
+
+	return nil
+}
+
+func (m *Tests) PackageWithDigest(ctx context.Context) error {
+	chart := dag.CurrentModule().Source().Directory("./testdata/chart")
+	helm := dag.HelmOci(dagger.HelmOciOpts{Base: dag.Container().From("alpine/helm:3.16.2")})
+
+	pkgs := helm.PackageWithDigest(chart)
+
+	sum, err := pkgs.File("example-0.2.0.tgz.sha256").Contents(ctx)
+	if err != nil {
+		return err
+	}
+
+	digest, err := helm.Digest(ctx, pkgs.File("example-0.2.0.tgz"))
+	if err != nil {
+		return err
+	}
+
+	expected := fmt.Sprintf("%s  example-0.2.0.tgz", strings.TrimPrefix(digest, "sha256:"))
+	if strings.TrimSpace(sum) != expected {
+		return fmt.Errorf("expected digest file to contain %s but found %s", expected, sum)
+	}
+
+	return nil
+}
@@ -12,22 +12,29 @@ import (
 	_ "embed"
 	"fmt"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 )
 
 const (
-	KubeconformGithubRepo         = "yannh/kubeconform"
-	KubeconformBaseImage          = "ghcr.io/yannh/kubeconform"
-	KubeconformWorkDir            = "/work"
-	KubeconformCRDFileFormat      = "{fullgroup}/{kind}_{version}"
-	KubeconformSchemaDir          = "schemas"
-	KubeconformSchemaLocationTmpl = "schemas/{{.Group}}/{{.ResourceKind}}_{{.ResourceAPIVersion}}.json"
+	KubeconformGithubRepo          = "yannh/kubeconform"
+	KubeconformBaseImage           = "ghcr.io/yannh/kubeconform"
+	KubeconformWorkDir             = "/work"
+	KubeconformCRDFileFormat       = "{fullgroup}/{kind}_{version}"
+	KubeconformSchemaDir           = "schemas"
+	KubeconformSchemaLocationTmpl  = "schemas/{{.Group}}/{{.ResourceKind}}_{{.ResourceAPIVersion}}.json"
+	KustomizeImage                 = "registry.k8s.io/kustomize/kustomize:v5.5.0"
+	KubeconformJunitReport         = "/tmp/kubeconform/junit.xml"
+	KubeconformOfflineSchemaDir    = "/offline-schemas"
+	KubeconformOfflineLocationTmpl = KubeconformOfflineSchemaDir + "/{{.NormalizedKubernetesVersion}}-standalone{{.StrictSuffix}}/{{.ResourceKind}}{{.KindSuffix}}.json"
 )
 
 //go:embed openapi2jsonschema.py
 var openapi2JsonSchema string
 
+var yamlDocSeparator = regexp.MustCompile(`(?m)^---\s*$`)
+
 // Kubeconform dagger module
 type Kubeconform struct {
 	// +private
@@ -36,6 +43,10 @@ type Kubeconform struct {
 	// +private
 	// +optional
 	Schemas *dagger.Directory
+
+	// +private
+	// +optional
+	OfflineSchemas *dagger.Directory
 }
 
 // Initializes the Kubeconform dagger module
@@ -151,6 +162,50 @@ func (m *Kubeconform) WithRemoteCRDs(
 	return m, nil
 }
 
+// Configures kubeconform to validate against a directory of pre-downloaded Kubernetes schemas,
+// disabling all network fetches. The directory must follow the same structure as
+// https://github.com/yannh/kubernetes-json-schema, e.g. v1.29.0-standalone-strict/configmap-v1.json.
+// Validation fails if the schemas for the requested Kubernetes version are not present
+func (m *Kubeconform) WithOfflineSchemas(
+	// a path to a directory containing pre-downloaded Kubernetes JSON schemas
+	// +required
+	schemas *dagger.Directory,
+) *Kubeconform {
+	m.OfflineSchemas = schemas
+	return m
+}
+
+func (m *Kubeconform) checkOfflineSchemas(ctx context.Context, kubernetesVersion string, strict bool, schemaLocation []string) error {
+	for _, loc := range schemaLocation {
+		if loc == "default" || strings.HasPrefix(loc, "http://") || strings.HasPrefix(loc, "https://") {
+			return fmt.Errorf("schema location %q requires network access and cannot be used with offline schemas", loc)
+		}
+	}
+
+	version := kubernetesVersion
+	if version != "master" && !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+
+	schemaDir := version + "-standalone"
+	if strict {
+		schemaDir += "-strict"
+	}
+
+	entries, err := m.OfflineSchemas.Entries(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if strings.TrimSuffix(entry, "/") == schemaDir {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("offline schemas for kubernetes version %s not found, expected directory %s", kubernetesVersion, schemaDir)
+}
+
 // Check and validate your Kubernertes manifests for conformity against the Kubernetes
 // OpenAPI specification. This flexibility, allows your manifests to be easily validated
 // against different Kubernetes versions. Includes support for validating against CRDs
@@ -176,6 +231,10 @@ func (m *Kubeconform) Validate(
 	// a path to a Kubernetes manifest file (YAML or JSON) for validation
 	// +optional
 	files []*dagger.File,
+	// a list of glob patterns for selecting files within each directory, e.g. manifests/**/*.yaml.
+	// If not provided, all files within each directory are validated
+	// +optional
+	patterns []string,
 	// a comma-separated list of kinds or GVKs to reject
 	// +optional
 	reject []string,
@@ -195,61 +254,204 @@ func (m *Kubeconform) Validate(
 	// +optional
 	summary bool,
 ) (string, error) {
+	ctr, cmd, err := m.validate(ctx, validateOpts{
+		Dirs:                  dirs,
+		IgnoreMissingSchemas:  ignoreMissingSchemas,
+		InsecureSkipTlsVerify: insecureSkipTlsVerify,
+		KubernetesVersion:     kubernetesVersion,
+		Goroutines:            goroutines,
+		Files:                 files,
+		Patterns:              patterns,
+		Reject:                reject,
+		SchemaLocation:        schemaLocation,
+		Show:                  show,
+		Skip:                  skip,
+		Strict:                strict,
+		Summary:               summary,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return ctr.WithExec(cmd).Stdout(ctx)
+}
+
+// Check and validate your Kubernetes manifests for conformity against the Kubernetes
+// OpenAPI specification, generating a JUnit report for surfacing the results within CI.
+// Each resource is reported as a separate test case. The report is always generated,
+// even if a manifest fails validation
+//
+// Examples:
+//
+// # Generate a JUnit report for all manifests within a directory
+// $ dagger call validate-junit --dirs ./manifests export --path kubeconform.xml
+func (m *Kubeconform) ValidateJunit(
+	ctx context.Context,
+	// a path to a directory containing Kubernetes manifests (YAML and JSON) for validation
+	// +optional
+	dirs []*dagger.Directory,
+	// skip files with missing schemas instead of failing
+	// +optional
+	ignoreMissingSchemas bool,
+	// disable verification of the server's SSL certificate
+	// +optional
+	insecureSkipTlsVerify bool,
+	// the version of kubernertes to validate against, e.g. 1.31.0
+	// +optional
+	// +default="master"
+	kubernetesVersion string,
+	// the number of goroutines to run concurrently during validation
+	// +optional
+	// +default=4
+	goroutines int,
+	// a path to a Kubernetes manifest file (YAML or JSON) for validation
+	// +optional
+	files []*dagger.File,
+	// a list of glob patterns for selecting files within each directory, e.g. manifests/**/*.yaml.
+	// If not provided, all files within each directory are validated
+	// +optional
+	patterns []string,
+	// a comma-separated list of kinds or GVKs to reject
+	// +optional
+	reject []string,
+	// override the schema search location path
+	// +optional
+	schemaLocation []string,
+	// a comma-separated list of kinds or GVKs to ignore
+	// +optional
+	skip []string,
+	// disallow additional properties not in schema or duplicated keys
+	// +optional
+	strict bool,
+) (*dagger.File, error) {
+	ctr, cmd, err := m.validate(ctx, validateOpts{
+		Dirs:                  dirs,
+		IgnoreMissingSchemas:  ignoreMissingSchemas,
+		InsecureSkipTlsVerify: insecureSkipTlsVerify,
+		KubernetesVersion:     kubernetesVersion,
+		Goroutines:            goroutines,
+		Files:                 files,
+		Patterns:              patterns,
+		Reject:                reject,
+		SchemaLocation:        schemaLocation,
+		Skip:                  skip,
+		Strict:                strict,
+		Output:                "junit",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctr, err = ctr.WithExec(cmd, dagger.ContainerWithExecOpts{
+		RedirectStdout: KubeconformJunitReport,
+		Expect:         dagger.ReturnTypeAny,
+	}).Sync(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// kubeconform exits with 1 if any resource fails validation
+	code, err := ctr.ExitCode(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if code > 1 {
+		stderr, _ := ctr.Stderr(ctx)
+		return nil, fmt.Errorf("kubeconform failed with exit code %d: %s", code, stderr)
+	}
+
+	return ctr.File(KubeconformJunitReport), nil
+}
+
+type validateOpts struct {
+	Dirs                  []*dagger.Directory
+	IgnoreMissingSchemas  bool
+	InsecureSkipTlsVerify bool
+	KubernetesVersion     string
+	Goroutines            int
+	Files                 []*dagger.File
+	Output                string
+	Patterns              []string
+	Reject                []string
+	SchemaLocation        []string
+	Show                  bool
+	Skip                  []string
+	Strict                bool
+	Summary               bool
+}
+
+// Prepares a container for validating the provided manifests, returning the kubeconform
+// command to execute
+func (m *Kubeconform) validate(ctx context.Context, opts validateOpts) (*dagger.Container, []string, error) {
 	cmd := []string{"kubeconform"}
-	if ignoreMissingSchemas {
+	if opts.IgnoreMissingSchemas {
 		cmd = append(cmd, "-ignore-missing-schemas")
 	}
 
-	if insecureSkipTlsVerify {
+	if opts.InsecureSkipTlsVerify {
 		cmd = append(cmd, "-insecure-skip-tls-verify")
 	}
 
-	if kubernetesVersion != "master" {
-		cmd = append(cmd, "-kubernetes-version", kubernetesVersion)
+	if opts.KubernetesVersion != "master" {
+		cmd = append(cmd, "-kubernetes-version", opts.KubernetesVersion)
 	}
 
-	if goroutines != 4 && goroutines > 0 {
-		cmd = append(cmd, "-n", strconv.Itoa(int(goroutines)))
+	if opts.Goroutines != 4 && opts.Goroutines > 0 {
+		cmd = append(cmd, "-n", strconv.Itoa(int(opts.Goroutines)))
 	}
 
-	if len(reject) > 0 {
-		cmd = append(cmd, "-reject", strings.Join(reject, ","))
+	if len(opts.Reject) > 0 {
+		cmd = append(cmd, "-reject", strings.Join(opts.Reject, ","))
 	}
 
-	if len(schemaLocation) > 0 {
-		for _, loc := range schemaLocation {
+	if len(opts.SchemaLocation) > 0 {
+		for _, loc := range opts.SchemaLocation {
 			cmd = append(cmd, "-schema-location", loc)
 		}
 	}
 
-	if len(skip) > 0 {
-		cmd = append(cmd, "-skip", strings.Join(skip, ","))
+	if len(opts.Skip) > 0 {
+		cmd = append(cmd, "-skip", strings.Join(opts.Skip, ","))
 	}
 
-	if strict {
+	if opts.Strict {
 		cmd = append(cmd, "-strict")
 	}
 
-	if summary {
+	if opts.Summary {
 		cmd = append(cmd, "-summary")
 	}
 
-	if show {
+	if opts.Show {
 		cmd = append(cmd, "-verbose")
 	}
 
+	if opts.Output != "" {
+		cmd = append(cmd, "-output", opts.Output)
+	}
+
 	ctr := m.Base.WithWorkdir(KubeconformWorkDir)
 
+	if m.OfflineSchemas != nil {
+		if err := m.checkOfflineSchemas(ctx, opts.KubernetesVersion, opts.Strict, opts.SchemaLocation); err != nil {
+			return nil, nil, err
+		}
+
+		ctr = ctr.WithDirectory(KubeconformOfflineSchemaDir, m.OfflineSchemas)
+		cmd = append(cmd, "-schema-location", KubeconformOfflineLocationTmpl)
+	}
+
 	if m.Schemas != nil {
 		ctr = ctr.WithDirectory(KubeconformWorkDir, m.Schemas)
 		cmd = append(cmd, "-schema-location", KubeconformSchemaLocationTmpl)
 	}
 
 	counter := 1
-	for _, file := range files {
+	for _, file := range opts.Files {
 		fname, err := file.Name(ctx)
 		if err != nil {
-			return "", err
+			return nil, nil, err
 		}
 
 		copyTo := filepath.Join(fmt.Sprintf("%03d", counter), fname)
@@ -259,13 +461,185 @@ func (m *Kubeconform) Validate(
 		counter++
 	}
 
-	for _, dir := range dirs {
+	for _, dir := range opts.Dirs {
 		copyTo := fmt.Sprintf("%03d", counter)
 		cmd = append(cmd, copyTo)
 
-		ctr = ctr.WithDirectory(copyTo, dir)
+		ctr = ctr.WithDirectory(copyTo, dir, dagger.ContainerWithDirectoryOpts{Include: opts.Patterns})
 		counter++
 	}
 
-	return ctr.WithExec(cmd).Stdout(ctx)
+	return ctr, cmd, nil
+}
+
+// Validates a rendered Helm chart, such as the file generated by helm template, for conformity
+// against the Kubernetes OpenAPI specification. The rendered manifest is split into a separate
+// file per YAML document, named after its source template, ensuring validation results can be
+// traced back to the template that generated them. Empty documents are discarded
+func (m *Kubeconform) ValidateHelm(
+	ctx context.Context,
+	// a rendered Helm chart containing multiple YAML documents
+	// +required
+	manifest *dagger.File,
+	// skip files with missing schemas instead of failing
+	// +optional
+	ignoreMissingSchemas bool,
+	// disable verification of the server's SSL certificate
+	// +optional
+	insecureSkipTlsVerify bool,
+	// the version of kubernertes to validate against, e.g. 1.31.0
+	// +optional
+	// +default="master"
+	kubernetesVersion string,
+	// the number of goroutines to run concurrently during validation
+	// +optional
+	// +default=4
+	goroutines int,
+	// a comma-separated list of kinds or GVKs to reject
+	// +optional
+	reject []string,
+	// override the schema search location path
+	// +optional
+	schemaLocation []string,
+	// print results for all resources (verbose)
+	// +optional
+	show bool,
+	// a comma-separated list of kinds or GVKs to ignore
+	// +optional
+	skip []string,
+	// disallow additional properties not in schema or duplicated keys
+	// +optional
+	strict bool,
+	// print a summary at the end
+	// +optional
+	summary bool,
+) (string, error) {
+	contents, err := manifest.Contents(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return m.Validate(
+		ctx,
+		[]*dagger.Directory{splitManifest(contents)},
+		ignoreMissingSchemas,
+		insecureSkipTlsVerify,
+		kubernetesVersion,
+		goroutines,
+		nil,
+		nil,
+		reject,
+		schemaLocation,
+		show,
+		skip,
+		strict,
+		summary,
+	)
+}
+
+func splitManifest(manifest string) *dagger.Directory {
+	dir := dag.Directory()
+
+	for i, doc := range yamlDocSeparator.Split(manifest, -1) {
+		var source string
+		empty := true
+		for _, line := range strings.Split(doc, "\n") {
+			line = strings.TrimSpace(line)
+			if src, found := strings.CutPrefix(line, "# Source:"); found {
+				source = strings.TrimSpace(src)
+				continue
+			}
+
+			if line != "" && !strings.HasPrefix(line, "#") {
+				empty = false
+			}
+		}
+
+		if empty {
+			continue
+		}
+
+		name := fmt.Sprintf("%03d-manifest.yaml", i)
+		if source != "" {
+			name = filepath.Join(filepath.Dir(source), fmt.Sprintf("%03d-%s", i, filepath.Base(source)))
+		}
+
+		dir = dir.WithNewFile(name, doc, dagger.DirectoryWithNewFileOpts{Permissions: 0o644})
+	}
+
+	return dir
+}
+
+// Builds a Kustomize overlay using kustomize build and validates the generated manifests for
+// conformity against the Kubernetes OpenAPI specification. Any configured CRD schemas are
+// validated against in the same way as Validate
+func (m *Kubeconform) ValidateKustomize(
+	ctx context.Context,
+	// a path to a directory containing the Kustomize overlays and bases
+	// +required
+	dir *dagger.Directory,
+	// the relative path of the overlay to build within the directory, containing a
+	// kustomization.yaml file
+	// +optional
+	// +default="."
+	path string,
+	// skip files with missing schemas instead of failing
+	// +optional
+	ignoreMissingSchemas bool,
+	// disable verification of the server's SSL certificate
+	// +optional
+	insecureSkipTlsVerify bool,
+	// the version of kubernertes to validate against, e.g. 1.31.0
+	// +optional
+	// +default="master"
+	kubernetesVersion string,
+	// the number of goroutines to run concurrently during validation
+	// +optional
+	// +default=4
+	goroutines int,
+	// a comma-separated list of kinds or GVKs to reject
+	// +optional
+	reject []string,
+	// override the schema search location path
+	// +optional
+	schemaLocation []string,
+	// print results for all resources (verbose)
+	// +optional
+	show bool,
+	// a comma-separated list of kinds or GVKs to ignore
+	// +optional
+	skip []string,
+	// disallow additional properties not in schema or duplicated keys
+	// +optional
+	strict bool,
+	// print a summary at the end
+	// +optional
+	summary bool,
+) (string, error) {
+	rendered := dag.Container().
+		From(KustomizeImage).
+		WithMountedDirectory(KubeconformWorkDir, dir).
+		WithWorkdir(KubeconformWorkDir).
+		WithExec([]string{"build", path}, dagger.ContainerWithExecOpts{
+			UseEntrypoint:  true,
+			RedirectStdout: "/tmp/kustomize.yaml",
+		}).
+		File("/tmp/kustomize.yaml")
+
+	return m.Validate(
+		ctx,
+		nil,
+		ignoreMissingSchemas,
+		insecureSkipTlsVerify,
+		kubernetesVersion,
+		goroutines,
+		[]*dagger.File{rendered},
+		nil,
+		reject,
+		schemaLocation,
+		show,
+		skip,
+		strict,
+		summary,
+	)
 }
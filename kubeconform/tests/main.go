@@ -29,6 +29,9 @@ var (
 
 	//go:embed testdata/serving-crds.yaml
 	servingCRDs string
+
+	//go:embed testdata/helm.yaml
+	helm string
 )
 
 type Tests struct{}
@@ -41,7 +44,13 @@ func (m *Tests) AllTests(ctx context.Context) error {
 	p.Go(m.ValidateWithLocalCRDs)
 	p.Go(m.ValidateWithRemoteCRDs)
 	p.Go(m.ValidateDirectory)
+	p.Go(m.ValidateDirectoryWithPatterns)
 	p.Go(m.ValidateInvalidFile)
+	p.Go(m.ValidateHelm)
+	p.Go(m.ValidateKustomize)
+	p.Go(m.ValidateWithOfflineSchemas)
+	p.Go(m.ValidateWithMissingOfflineSchemas)
+	p.Go(m.ValidateJunit)
 
 	return p.Wait()
 }
@@ -160,6 +169,36 @@ func (m *Tests) ValidateDirectory(ctx context.Context) error {
 	return nil
 }
 
+func (m *Tests) ValidateDirectoryWithPatterns(ctx context.Context) error {
+	manifests := dag.Directory().
+		WithNewFile("manifests/app/valid.yaml", valid, dagger.DirectoryWithNewFileOpts{Permissions: 0o644}).
+		WithNewFile("manifests/app/invalid.yml", invalid, dagger.DirectoryWithNewFileOpts{Permissions: 0o644}).
+		WithNewFile("manifests/README.md", "# Manifests", dagger.DirectoryWithNewFileOpts{Permissions: 0o644})
+
+	opts := dagger.KubeconformValidateOpts{
+		Dirs:     []*dagger.Directory{manifests},
+		Patterns: []string{"manifests/**/*.yaml"},
+		Summary:  true,
+	}
+
+	actual, err := dag.Kubeconform().Validate(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	expected := "Summary: 6 resources found in 1 file - Valid: 6, Invalid: 0, Errors: 0, Skipped: 0"
+	if idx := strings.Index(actual, "Summary:"); idx != -1 {
+		actual = strings.TrimSpace(actual[idx:])
+	}
+
+	if actual != expected {
+		return fmt.Errorf("kubeconform summary does not match:\n%v",
+			diff.LineDiff(expected, actual))
+	}
+
+	return nil
+}
+
 func (m *Tests) ValidateInvalidFile(ctx context.Context) error {
 	manifest := dag.Directory().
 		WithNewFile("invalid.yaml", invalid, dagger.DirectoryWithNewFileOpts{Permissions: 0o644}).
@@ -186,3 +225,156 @@ func (m *Tests) ValidateInvalidFile(ctx context.Context) error {
 
 	return nil
 }
+
+func (m *Tests) ValidateHelm(ctx context.Context) error {
+	manifest := dag.Directory().
+		WithNewFile("helm.yaml", helm, dagger.DirectoryWithNewFileOpts{Permissions: 0o644}).
+		File("helm.yaml")
+
+	opts := dagger.KubeconformValidateHelmOpts{
+		Show:    true,
+		Summary: true,
+	}
+
+	actual, err := dag.Kubeconform().ValidateHelm(ctx, manifest, opts)
+	if err != nil {
+		return err
+	}
+
+	expected := "Summary: 3 resources found in 3 files - Valid: 3, Invalid: 0, Errors: 0, Skipped: 0"
+	if idx := strings.Index(actual, "Summary:"); idx != -1 {
+		actual = strings.TrimSpace(actual[idx:])
+	}
+
+	if actual != expected {
+		return fmt.Errorf("kubeconform summary does not match:\n%v",
+			diff.LineDiff(expected, actual))
+	}
+
+	return nil
+}
+
+const (
+	configMap = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: offline
+data:
+  key: value
+`
+
+	configMapSchema = `{
+  "type": "object",
+  "required": ["apiVersion", "kind", "metadata"],
+  "properties": {
+    "apiVersion": {"type": "string"},
+    "kind": {"type": "string"},
+    "metadata": {"type": "object"},
+    "data": {"type": "object", "additionalProperties": {"type": "string"}}
+  }
+}`
+)
+
+func (m *Tests) ValidateKustomize(ctx context.Context) error {
+	dir := dag.CurrentModule().Source().Directory("./testdata/kustomize")
+
+	opts := dagger.KubeconformValidateKustomizeOpts{
+		Path:    "overlays/prod",
+		Show:    true,
+		Summary: true,
+	}
+
+	actual, err := dag.Kubeconform().ValidateKustomize(ctx, dir, opts)
+	if err != nil {
+		return err
+	}
+
+	expected := "Summary: 3 resources found in 1 file - Valid: 3, Invalid: 0, Errors: 0, Skipped: 0"
+	if idx := strings.Index(actual, "Summary:"); idx != -1 {
+		actual = strings.TrimSpace(actual[idx:])
+	}
+
+	if actual != expected {
+		return fmt.Errorf("kubeconform summary does not match:\n%v",
+			diff.LineDiff(expected, actual))
+	}
+
+	return nil
+}
+
+func (m *Tests) ValidateWithOfflineSchemas(ctx context.Context) error {
+	manifest := dag.Directory().
+		WithNewFile("configmap.yaml", configMap, dagger.DirectoryWithNewFileOpts{Permissions: 0o644}).
+		File("configmap.yaml")
+
+	schemas := dag.Directory().
+		WithNewFile("v1.29.0-standalone/configmap-v1.json", configMapSchema)
+
+	opts := dagger.KubeconformValidateOpts{
+		Files:             []*dagger.File{manifest},
+		KubernetesVersion: "1.29.0",
+		Summary:           true,
+	}
+
+	out, err := dag.Kubeconform().
+		WithOfflineSchemas(schemas).
+		Validate(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	expected := "Summary: 1 resource found in 1 file - Valid: 1, Invalid: 0, Errors: 0, Skipped: 0"
+	if !strings.Contains(out, expected) {
+		return fmt.Errorf("kubeconform summary does not match:\n%s", diff.LineDiff(out, expected))
+	}
+
+	return nil
+}
+
+func (m *Tests) ValidateWithMissingOfflineSchemas(ctx context.Context) error {
+	manifest := dag.Directory().
+		WithNewFile("configmap.yaml", configMap, dagger.DirectoryWithNewFileOpts{Permissions: 0o644}).
+		File("configmap.yaml")
+
+	schemas := dag.Directory().
+		WithNewFile("v1.29.0-standalone/configmap-v1.json", configMapSchema)
+
+	opts := dagger.KubeconformValidateOpts{
+		Files:             []*dagger.File{manifest},
+		KubernetesVersion: "1.30.0",
+	}
+
+	_, err := dag.Kubeconform().
+		WithOfflineSchemas(schemas).
+		Validate(ctx, opts)
+	if err == nil {
+		return fmt.Errorf("expected validation to fail with missing offline schemas")
+	}
+
+	if !strings.Contains(err.Error(), "v1.30.0-standalone") {
+		return fmt.Errorf("unexpected error when offline schemas are missing: %w", err)
+	}
+
+	return nil
+}
+
+func (m *Tests) ValidateJunit(ctx context.Context) error {
+	manifests := dag.Directory().
+		WithNewFile("valid.yaml", valid, dagger.DirectoryWithNewFileOpts{Permissions: 0o644}).
+		WithNewFile("invalid.yaml", invalid, dagger.DirectoryWithNewFileOpts{Permissions: 0o644})
+
+	opts := dagger.KubeconformValidateJunitOpts{
+		Dirs: []*dagger.Directory{manifests},
+	}
+
+	report, err := dag.Kubeconform().ValidateJunit(opts).Contents(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !strings.Contains(report, "<testsuites") || !strings.Contains(report, "<failure") {
+		return fmt.Errorf("junit report does not contain any failing test cases:\n%s", report)
+	}
+
+	return nil
+}
@@ -11,14 +11,41 @@
 // config file. Entirely convention-based, you can adapt your workflow from within your commit message.
 //
 // The power is at your fingertips.
+//
+// Settings can be kept in source control within a YAML configuration file, and loaded
+// through the cfg flag. Any explicitly provided flag takes precedence over the file:
+//
+//	excludePaths: ["docs", "vendor"]
+//	fetchTags: true
+//	fixShallow: true
+//	format: "v{{.Version}}"
+//	hook: ./scripts/patch.sh
+//	majorPrefixes: ["feat!"]
+//	minorPrefixes: ["feat"]
+//	patchPrefixes: ["fix", "perf"]
+//	paths: ["services/api"]
+//	versionFiles: ["Cargo.toml", "package.json"]
+//
+// The hook setting is only used when tagging or patching, while version files are only
+// patched when patching. When executed, the hook can
+// read the following environment variables:
+//
+//	NSV_NEXT_TAG: the next semantic version that the repository will be tagged with. When
+//	analyzing multiple paths, a space separated list of tags, one for each path
 package main
 
 import (
 	"context"
 	"fmt"
+	"path"
+	"regexp"
+	"slices"
 	"strings"
+	"text/template"
 
 	"dagger/nsv/internal/dagger"
+
+	"gopkg.in/yaml.v3"
 )
 
 const (
@@ -80,8 +107,31 @@ func New(
 
 // Prints the next semantic version based on the commit history of your repository.
 // Documentation on Go Template support can be found at: https://docs.purpleclay.dev/nsv/reference/templating/
+//
+// Examples:
+//
+// # Print a changelog fragment for the next semantic version
+// $ dagger call --src . next --changelog
 func (n *Nsv) Next(
 	ctx context.Context,
+	// a YAML configuration file containing settings for calculating the next
+	// semantic version. Explicitly provided flags take precedence
+	// +optional
+	cfg *dagger.File,
+	// print a markdown changelog fragment for the next semantic version, grouping
+	// conventional commits by the prefixes that triggered the version increment.
+	// Cannot be used in conjunction with the show flag
+	// +optional
+	changelog bool,
+	// a list of relative paths to exclude from analysis, such as documentation or vendored
+	// code. Any commit since the latest tag that only changes excluded paths is ignored when
+	// calculating the next semantic version
+	// +optional
+	excludePaths []string,
+	// fetch all tags from the remote before calculating the next semantic version,
+	// through git fetch --tags. Useful within CI, where a checkout may not include tags
+	// +optional
+	fetchTags bool,
 	// fix a shallow clone of a repository if detected
 	// +optional
 	fixShallow bool,
@@ -109,77 +159,229 @@ func (n *Nsv) Next(
 	// +optional
 	// +default="full"
 	pretty string,
-	// show how the next semantic version was calculated
+	// show how the next semantic version was calculated, including a warning if
+	// the history of the repository is incomplete
 	// +optional
 	show bool,
 ) (string, error) {
+	vargs, _, err := loadConfig(ctx, cfg, "", versionArgs{
+		ExcludePaths:  excludePaths,
+		FetchTags:     fetchTags,
+		FixShallow:    fixShallow,
+		Format:        format,
+		MajorPrefixes: majorPrefixes,
+		MinorPrefixes: minorPrefixes,
+		PatchPrefixes: patchPrefixes,
+		Paths:         paths,
+		Pretty:        pretty,
+		Show:          show,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if changelog && show {
+		return "", fmt.Errorf("changelog cannot be used in conjunction with the show flag")
+	}
+
+	ctr, warning, err := prepareHistory(ctx, n.Base, vargs)
+	if err != nil {
+		return "", err
+	}
+
+	if changelog {
+		return changelogFragment(ctx, ctr, vargs)
+	}
+
 	cmd := []string{"next"}
-	cmd = append(cmd, formatArgs(
-		fixShallow,
-		format,
-		majorPrefixes,
-		minorPrefixes,
-		patchPrefixes,
-		pretty,
-		show,
-		paths,
-	)...)
-
-	return n.Base.
+	cmd = append(cmd, vargs.args()...)
+
+	out, err := ctr.
 		WithExec(cmd, dagger.ContainerWithExecOpts{UseEntrypoint: true}).
 		Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	// The version alone is printed by default, as it is typically consumed by scripts
+	if show {
+		out = withWarning(out, warning)
+	}
+
+	return out, nil
 }
 
-func formatArgs(
-	fixShallow bool,
-	format string,
-	majorPrefixes, minorPrefixes, patchPrefixes []string,
-	pretty string,
-	show bool,
-	paths []string,
-) []string {
+type versionArgs struct {
+	ExcludePaths   []string
+	FetchTags      bool
+	FixShallow     bool
+	Format         string
+	MajorPrefixes  []string
+	MinorPrefixes  []string
+	PatchPrefixes  []string
+	Paths          []string
+	Pretty         string
+	Show           bool
+	VersionFiles   []string
+	VersionPattern string
+}
+
+func (a versionArgs) args() []string {
 	var args []string
 
-	if fixShallow {
+	if a.FixShallow {
 		args = append(args, "--fix-shallow")
 	}
 
-	if format != "" {
-		args = append(args, "--format", format)
+	if a.Format != "" {
+		args = append(args, "--format", a.Format)
 	}
 
-	if show {
-		args = append(args, "--show", fmt.Sprintf("--pretty=%s", pretty))
+	if a.Show {
+		args = append(args, "--show", fmt.Sprintf("--pretty=%s", a.Pretty))
 	}
 
-	if len(majorPrefixes) > 0 {
-		args = append(args, "--major-prefixes", strings.Join(majorPrefixes, ","))
+	if len(a.MajorPrefixes) > 0 {
+		args = append(args, "--major-prefixes", strings.Join(a.MajorPrefixes, ","))
 	}
 
-	if len(minorPrefixes) > 0 {
-		args = append(args, "--minor-prefixes", strings.Join(minorPrefixes, ","))
+	if len(a.MinorPrefixes) > 0 {
+		args = append(args, "--minor-prefixes", strings.Join(a.MinorPrefixes, ","))
 	}
 
-	if len(patchPrefixes) > 0 {
-		args = append(args, "--patch-prefixes", strings.Join(patchPrefixes, ","))
+	if len(a.PatchPrefixes) > 0 {
+		args = append(args, "--patch-prefixes", strings.Join(a.PatchPrefixes, ","))
 	}
 
-	if len(paths) > 0 {
-		args = append(args, paths...)
+	if len(a.Paths) > 0 {
+		args = append(args, a.Paths...)
 	}
 
 	return args
 }
 
+// Settings loaded from a YAML configuration file
+type fileConfig struct {
+	ExcludePaths   []string `yaml:"excludePaths"`
+	FetchTags      bool     `yaml:"fetchTags"`
+	FixShallow     bool     `yaml:"fixShallow"`
+	Format         string   `yaml:"format"`
+	Hook           string   `yaml:"hook"`
+	MajorPrefixes  []string `yaml:"majorPrefixes"`
+	MinorPrefixes  []string `yaml:"minorPrefixes"`
+	PatchPrefixes  []string `yaml:"patchPrefixes"`
+	Paths          []string `yaml:"paths"`
+	VersionFiles   []string `yaml:"versionFiles"`
+	VersionPattern string   `yaml:"versionPattern"`
+}
+
+// Loads settings from an optional YAML configuration file, merging them with any
+// explicitly provided flags, which take precedence
+func loadConfig(ctx context.Context, cfg *dagger.File, hook string, vargs versionArgs) (versionArgs, string, error) {
+	if cfg == nil {
+		return vargs, hook, nil
+	}
+
+	contents, err := cfg.Contents(ctx)
+	if err != nil {
+		return vargs, hook, err
+	}
+
+	var conf fileConfig
+	if err := yaml.Unmarshal([]byte(contents), &conf); err != nil {
+		return vargs, hook, fmt.Errorf("failed to parse configuration file: %w", err)
+	}
+
+	vargs.FetchTags = vargs.FetchTags || conf.FetchTags
+	vargs.FixShallow = vargs.FixShallow || conf.FixShallow
+	if vargs.Format == "" {
+		vargs.Format = conf.Format
+	}
+
+	if len(vargs.MajorPrefixes) == 0 {
+		vargs.MajorPrefixes = conf.MajorPrefixes
+	}
+
+	if len(vargs.MinorPrefixes) == 0 {
+		vargs.MinorPrefixes = conf.MinorPrefixes
+	}
+
+	if len(vargs.PatchPrefixes) == 0 {
+		vargs.PatchPrefixes = conf.PatchPrefixes
+	}
+
+	if len(vargs.Paths) == 0 {
+		vargs.Paths = conf.Paths
+	}
+
+	if len(vargs.ExcludePaths) == 0 {
+		vargs.ExcludePaths = conf.ExcludePaths
+	}
+
+	if len(vargs.VersionFiles) == 0 {
+		vargs.VersionFiles = conf.VersionFiles
+	}
+
+	if vargs.VersionPattern == "" {
+		vargs.VersionPattern = conf.VersionPattern
+	}
+
+	if hook == "" {
+		hook = conf.Hook
+	}
+
+	return vargs, hook, nil
+}
+
 // Tags the next semantic version based on the commit history of your repository.
 // Includes experimental support for patching files through a custom hook.
 // Documentation on Go Template support can be found at: https://docs.purpleclay.dev/nsv/reference/templating/
+//
+// By default an annotated tag is created. A lightweight tag can be created instead, but
+// signing a tag with GPG always requires an annotated tag, as a lightweight tag is
+// nothing more than a reference to a commit and cannot carry a signature.
+//
+// Projects within a monorepo can be tagged independently by enabling monorepo mode, with
+// each path receiving its own prefixed tag, e.g. service-a/v1.2.0 and service-b/v0.3.1.
+// The prefix is rendered from a configurable template and prepended to the version, which
+// continues to be controlled by the format flag. For example, a tag prefix of
+// "releases/{{.Name}}-" and a format of "v{{.Version}}" creates the tag releases/service-a-v1.2.0.
+//
+// Every calculated tag is validated as a git ref name before the repository is tagged,
+// and can optionally be sanitized by replacing any invalid characters.
 func (n *Nsv) Tag(
 	ctx context.Context,
+	// create an annotated tag, containing a tag message and optional GPG signature. If
+	// disabled, a lightweight tag is created and the tag message is ignored. Cannot be
+	// disabled when signing the tag with a GPG private key
+	// +optional
+	// +default=true
+	annotated bool,
+	// the email address of the author and committer when committing file changes,
+	// set through git config user.email
+	// +optional
+	authorEmail string,
+	// the name of the author and committer when committing file changes, set
+	// through git config user.name
+	// +optional
+	authorName string,
+	// a YAML configuration file containing settings for calculating the next
+	// semantic version. Explicitly provided flags take precedence
+	// +optional
+	cfg *dagger.File,
 	// a custom message when committing file changes, supports go text templates
 	// +optional
 	// +default="chore: patched files for release {{.Tag}} {{.SkipPipelineTag}}"
 	commitMessage string,
+	// a list of relative paths to exclude from analysis, such as documentation or vendored
+	// code. Any commit since the latest tag that only changes excluded paths is ignored when
+	// calculating the next semantic version
+	// +optional
+	excludePaths []string,
+	// fetch all tags from the remote before calculating the next semantic version,
+	// through git fetch --tags. Useful within CI, where a checkout may not include tags
+	// +optional
+	fetchTags bool,
 	// fix a shallow clone of a repository if detected
 	// +optional
 	fixShallow bool,
@@ -193,7 +395,8 @@ func (n *Nsv) Tag(
 	// +optional
 	gpgPrivateKey *dagger.Secret,
 	// a user-defined hook that will be executed before the repository is tagged
-	// with the next semantic version. Can be inline shell or a path to a script
+	// with the next semantic version. Can be inline shell or a path to a script.
+	// The next semantic version is available through the NSV_NEXT_TAG environment variable
 	// +optional
 	hook string,
 	// a comma separated list of conventional commit prefixes for triggering a
@@ -204,6 +407,10 @@ func (n *Nsv) Tag(
 	// minor semantic version increment
 	// +optional
 	minorPrefixes []string,
+	// tag each of the provided paths independently, using a tag prefix to distinguish
+	// between the projects within a monorepo. Each tag is created using git directly
+	// +optional
+	monorepo bool,
 	// a comma separated list of conventional commit prefixes for triggering a
 	// patch semantic version increment
 	// +optional
@@ -217,14 +424,103 @@ func (n *Nsv) Tag(
 	// +optional
 	// +default="full"
 	pretty string,
-	// show how the next semantic version was calculated
+	// replace any characters within the calculated tag that are invalid within a git ref
+	// name with a hyphen, rather than failing. Useful when the format includes dynamic CI
+	// variables, such as a branch name. A sanitized tag is created using git directly and
+	// cannot be signed with GPG
+	// +optional
+	sanitize bool,
+	// show how the next semantic version was calculated, including a warning if
+	// the history of the repository is incomplete
 	// +optional
 	show bool,
-	// a custom message for the tag, supports go text templates
+	// sign the commit containing any patched files using the provided GPG private key,
+	// through git config commit.gpgsign
+	// +optional
+	signCommits bool,
+	// a custom message for the tag, supports go text templates. Ignored when
+	// creating a lightweight tag
 	// +optional
 	// +default="chore: tagged release {{.Tag}}"
 	tagMessage string,
+	// a go template for the prefix of each tag when tagging a monorepo, supporting both
+	// {{.Path}} (the relative path of the project) and {{.Name}} (the last element of the
+	// path). The prefix is prepended to the version formatted by the format flag, and must
+	// be unique to each project, e.g. {{.Name}}/ and v{{.Version}} creates service-a/v1.2.0
+	// +optional
+	// +default="{{.Name}}/"
+	tagPrefix string,
 ) (string, error) {
+	vargs, hook, err := loadConfig(ctx, cfg, hook, versionArgs{
+		ExcludePaths:  excludePaths,
+		FetchTags:     fetchTags,
+		FixShallow:    fixShallow,
+		Format:        format,
+		MajorPrefixes: majorPrefixes,
+		MinorPrefixes: minorPrefixes,
+		PatchPrefixes: patchPrefixes,
+		Paths:         paths,
+		Pretty:        pretty,
+		Show:          show,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if signCommits && gpgPrivateKey == nil {
+		return "", fmt.Errorf("signing commits requires a GPG private key")
+	}
+
+	ctr, warning, err := prepareHistory(ctx, n.Base, vargs)
+	if err != nil {
+		return "", err
+	}
+
+	// The tag alone is printed by default, as it is typically consumed by scripts
+	if !show {
+		warning = ""
+	}
+	ctr = configureGitIdentity(ctr, authorName, authorEmail)
+
+	if monorepo {
+		if gpgPrivateKey != nil || hook != "" {
+			return "", fmt.Errorf("signing or patching files is not supported when tagging a monorepo")
+		}
+
+		out, err := monorepoTag(ctx, ctr, annotated, tagMessage, tagPrefix, sanitize, vargs)
+		return withWarning(out, warning), err
+	}
+
+	if !annotated && gpgPrivateKey != nil {
+		return "", fmt.Errorf("signing a tag with GPG requires an annotated tag")
+	}
+
+	// Validate the tag upfront, preventing a half-created tag if git rejects it
+	tag, err := nextVersion(ctx, ctr, vargs)
+	if err != nil {
+		return "", err
+	}
+
+	// When analyzing multiple paths, nsv calculates a tag per path, one per line
+	var sanitized bool
+	for _, t := range strings.Fields(tag) {
+		if err := validateTagName(t); err != nil {
+			if !sanitize {
+				return "", err
+			}
+
+			if gpgPrivateKey != nil {
+				return "", fmt.Errorf("%w, a sanitized tag cannot be signed with GPG", err)
+			}
+			sanitized = true
+		}
+	}
+
+	if !annotated || sanitized {
+		out, err := gitTag(ctx, ctr, annotated, commitMessage, tagMessage, hook, sanitized, tag, vargs)
+		return withWarning(out, warning), err
+	}
+
 	cmd := []string{"tag"}
 	if commitMessage != "" {
 		cmd = append(cmd, "--commit-message", commitMessage)
@@ -238,31 +534,59 @@ func (n *Nsv) Tag(
 		cmd = append(cmd, "--hook", hook)
 	}
 
-	cmd = append(cmd, formatArgs(
-		fixShallow,
-		format,
-		majorPrefixes,
-		minorPrefixes,
-		patchPrefixes,
-		pretty,
-		show,
-		paths,
-	)...)
+	cmd = append(cmd, vargs.args()...)
 
-	return configureGPG(n.Base, gpgPrivateKey, gpgPassphrase).
+	out, err := configureGPG(withHookEnv(ctr, hook, strings.Fields(tag)), gpgPrivateKey, gpgPassphrase, signCommits).
 		WithExec(cmd, dagger.ContainerWithExecOpts{UseEntrypoint: true}).
 		Stdout(ctx)
+	return withWarning(out, warning), err
 }
 
 // Patch files in a repository with the next semantic version based on the conventional
 // commit history of your repository.
 // Documentation on Go Template support can be found at: https://docs.purpleclay.dev/nsv/reference/templating/
+//
+// Common version files can be patched without a custom hook. The version is written into
+// the first match of a built-in pattern, based on the type of file:
+//
+//	*.toml (Cargo.toml, pyproject.toml): version = "1.2.3"
+//	*.json (package.json): "version": "1.2.3"
+//	any other file (version.txt): the entire file is replaced with the version
+//
+// Examples:
+//
+// # Patch the version within a Cargo.toml and package.json
+// $ dagger call patch --version-files Cargo.toml,package.json
+//
+// # Patch the version within a Go source file, using a custom pattern
+// $ dagger call patch --version-files internal/version.go --version-pattern 'Version = "([^"]*)"'
 func (n *Nsv) Patch(
 	ctx context.Context,
+	// the email address of the author and committer when committing file changes,
+	// set through git config user.email
+	// +optional
+	authorEmail string,
+	// the name of the author and committer when committing file changes, set
+	// through git config user.name
+	// +optional
+	authorName string,
+	// a YAML configuration file containing settings for calculating the next
+	// semantic version. Explicitly provided flags take precedence
+	// +optional
+	cfg *dagger.File,
 	// a custom message when committing file changes, supports go text templates
 	// +optional
 	// +default="chore: patched files for release {{.Tag}} {{.SkipPipelineTag}}"
 	commitMessage string,
+	// a list of relative paths to exclude from analysis, such as documentation or vendored
+	// code. Any commit since the latest tag that only changes excluded paths is ignored when
+	// calculating the next semantic version
+	// +optional
+	excludePaths []string,
+	// fetch all tags from the remote before calculating the next semantic version,
+	// through git fetch --tags. Useful within CI, where a checkout may not include tags
+	// +optional
+	fetchTags bool,
 	// fix a shallow clone of a repository if detected
 	// +optional
 	fixShallow bool,
@@ -276,7 +600,8 @@ func (n *Nsv) Patch(
 	// +optional
 	gpgPrivateKey *dagger.Secret,
 	// a user-defined hook that will be executed before the repository is tagged
-	// with the next semantic version. Can be inline shell or a path to a script
+	// with the next semantic version. Can be inline shell or a path to a script.
+	// The next semantic version is available through the NSV_NEXT_TAG environment variable
 	// +optional
 	hook string,
 	// a comma separated list of conventional commit prefixes for triggering a
@@ -300,10 +625,70 @@ func (n *Nsv) Patch(
 	// +optional
 	// +default="full"
 	pretty string,
-	// show how the next semantic version was calculated
+	// show how the next semantic version was calculated, including a warning if
+	// the history of the repository is incomplete
 	// +optional
 	show bool,
+	// sign the commit containing any patched files using the provided GPG private key,
+	// through git config commit.gpgsign
+	// +optional
+	signCommits bool,
+	// a list of relative paths to version files that will be patched with the next
+	// semantic version, without the need for a custom hook. Patched after any hook
+	// +optional
+	versionFiles []string,
+	// a regex for locating the version within each version file, the first capture group
+	// is replaced with the next semantic version. Overrides the built-in patterns
+	// +optional
+	versionPattern string,
 ) (string, error) {
+	vargs, hook, err := loadConfig(ctx, cfg, hook, versionArgs{
+		ExcludePaths:   excludePaths,
+		FetchTags:      fetchTags,
+		FixShallow:     fixShallow,
+		Format:         format,
+		MajorPrefixes:  majorPrefixes,
+		MinorPrefixes:  minorPrefixes,
+		PatchPrefixes:  patchPrefixes,
+		Paths:          paths,
+		Pretty:         pretty,
+		Show:           show,
+		VersionFiles:   versionFiles,
+		VersionPattern: versionPattern,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if signCommits && gpgPrivateKey == nil {
+		return "", fmt.Errorf("signing commits requires a GPG private key")
+	}
+
+	ctr, warning, err := prepareHistory(ctx, n.Base, vargs)
+	if err != nil {
+		return "", err
+	}
+
+	// The output of nsv alone is printed by default, as it is typically consumed by scripts
+	if !show {
+		warning = ""
+	}
+
+	// The next semantic version is calculated before any files are patched
+	var tags []string
+	if hook != "" || len(vargs.VersionFiles) > 0 {
+		out, err := nextVersion(ctx, ctr, vargs)
+		if err != nil {
+			return "", err
+		}
+		tags = strings.Fields(out)
+	}
+
+	ctr, hook, err = withVersionFiles(ctx, ctr, hook, tags, vargs)
+	if err != nil {
+		return "", err
+	}
+
 	cmd := []string{"patch"}
 	if commitMessage != "" {
 		cmd = append(cmd, "--commit-message", commitMessage)
@@ -313,27 +698,726 @@ func (n *Nsv) Patch(
 		cmd = append(cmd, "--hook", hook)
 	}
 
-	cmd = append(cmd, formatArgs(
-		fixShallow,
-		format,
-		majorPrefixes,
-		minorPrefixes,
-		patchPrefixes,
-		pretty,
-		show,
-		paths,
-	)...)
+	cmd = append(cmd, vargs.args()...)
 
-	return configureGPG(n.Base, gpgPrivateKey, gpgPassphrase).
+	ctr = withHookEnv(configureGitIdentity(ctr, authorName, authorEmail), hook, tags)
+
+	out, err := configureGPG(ctr, gpgPrivateKey, gpgPassphrase, signCommits).
 		WithExec(cmd, dagger.ContainerWithExecOpts{UseEntrypoint: true}).
 		Stdout(ctx)
+	return withWarning(out, warning), err
+}
+
+// Tags the repository using git directly. As nsv only creates annotated tags from the version
+// it calculates, git is used for lightweight tags, or when a tag must be sanitized. If a hook
+// is provided, files are patched beforehand, mirroring the behavior of nsv. The next
+// semantic version must already have been calculated, with one tag per line
+func gitTag(
+	ctx context.Context,
+	ctr *dagger.Container,
+	annotated bool,
+	commitMessage string,
+	tagMessage string,
+	hook string,
+	sanitize bool,
+	next string,
+	vargs versionArgs,
+) (string, error) {
+	// Only the calculated version should be printed
+	vargs.Show = false
+
+	// When analyzing multiple paths, nsv calculates a tag per path, one per line
+	var tags []string
+	for _, tag := range strings.Fields(next) {
+		if sanitize {
+			tag = sanitizeTagName(tag)
+		}
+
+		if err := validateTagName(tag); err != nil {
+			return "", err
+		}
+		tags = append(tags, tag)
+	}
+
+	if len(tags) == 0 {
+		return "", nil
+	}
+
+	if hook != "" {
+		cmd := []string{"patch", "--hook", hook}
+		if commitMessage != "" {
+			cmd = append(cmd, "--commit-message", commitMessage)
+		}
+
+		cmd = append(cmd, vargs.args()...)
+
+		ctr = withHookEnv(ctr, hook, tags).
+			WithExec(cmd, dagger.ContainerWithExecOpts{UseEntrypoint: true})
+	}
+
+	for _, tag := range tags {
+		tagCmd := []string{"git", "tag", tag}
+		if annotated {
+			msg, err := renderTagMessage(tagMessage, tag)
+			if err != nil {
+				return "", err
+			}
+			tagCmd = []string{"git", "tag", "-a", tag, "-m", msg}
+		}
+
+		ctr = ctr.WithExec(tagCmd)
+	}
+
+	_, err := ctr.
+		WithExec(append([]string{"git", "push", "origin"}, tags...)).
+		Sync(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join(tags, "\n"), nil
+}
+
+// Calculates the next semantic version using nsv, returning an empty string if no
+// version was calculated
+func nextVersion(ctx context.Context, ctr *dagger.Container, vargs versionArgs) (string, error) {
+	vargs.Show = false
+
+	cmd := []string{"next"}
+	cmd = append(cmd, vargs.args()...)
+
+	out, err := ctr.WithExec(cmd, dagger.ContainerWithExecOpts{UseEntrypoint: true}).Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(out), nil
+}
+
+// Default conventional commit prefixes used by nsv when none are configured
+var (
+	defaultMinorPrefixes = []string{"feat"}
+	defaultPatchPrefixes = []string{"fix"}
+)
+
+type changelogGroup struct {
+	Title    string
+	Prefixes []string
+	Entries  []string
+}
+
+// Generates a markdown changelog fragment for the next semantic version from the
+// conventional commits made since the latest tag. Commits are grouped by the prefix
+// categories used to calculate the version. An empty string is returned if no
+// version was calculated
+func changelogFragment(ctx context.Context, ctr *dagger.Container, vargs versionArgs) (string, error) {
+	if len(vargs.Paths) > 1 {
+		return "", fmt.Errorf("a changelog can only be generated for a single path")
+	}
+
+	version, err := nextVersion(ctx, ctr, vargs)
+	if err != nil {
+		return "", err
+	}
+
+	if version == "" {
+		return "", nil
+	}
+
+	out, err := unreleasedCommits(ctx, ctr, vargs, "%s%x1f%b%x1e")
+	if err != nil {
+		return "", err
+	}
+
+	minorPrefixes := vargs.MinorPrefixes
+	if len(minorPrefixes) == 0 {
+		minorPrefixes = defaultMinorPrefixes
+	}
+
+	patchPrefixes := vargs.PatchPrefixes
+	if len(patchPrefixes) == 0 {
+		patchPrefixes = defaultPatchPrefixes
+	}
+
+	groups := []*changelogGroup{
+		{Title: "Breaking Changes", Prefixes: vargs.MajorPrefixes},
+		{Title: "Features", Prefixes: minorPrefixes},
+		{Title: "Bug Fixes", Prefixes: patchPrefixes},
+	}
+
+	for _, commit := range strings.Split(out, "\x1e") {
+		subject, body, _ := strings.Cut(strings.TrimSpace(commit), "\x1f")
+		if subject == "" {
+			continue
+		}
+
+		prefix, desc, ok := strings.Cut(subject, ":")
+		if !ok {
+			continue
+		}
+
+		breaking := strings.HasSuffix(prefix, "!") || strings.Contains(body, "BREAKING CHANGE")
+		typ, scope, _ := strings.Cut(strings.TrimSuffix(prefix, "!"), "(")
+
+		entry := strings.TrimSpace(desc)
+		if scope = strings.TrimSuffix(scope, ")"); scope != "" {
+			entry = fmt.Sprintf("**%s:** %s", scope, entry)
+		}
+
+		for i, group := range groups {
+			if (i == 0 && breaking) || matchesPrefix(prefix, typ, group.Prefixes) {
+				group.Entries = append(group.Entries, entry)
+				break
+			}
+		}
+	}
+
+	var fragment strings.Builder
+	fmt.Fprintf(&fragment, "## %s\n", version)
+	for _, group := range groups {
+		if len(group.Entries) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(&fragment, "\n### %s\n\n", group.Title)
+		for _, entry := range group.Entries {
+			fmt.Fprintf(&fragment, "- %s\n", entry)
+		}
+	}
+
+	return fragment.String(), nil
+}
+
+// Lists the commits made since the latest tag using git log with the given format. Commits
+// are limited to those changing the analyzed paths, ignoring any changes to excluded paths.
+// If no tag exists, the entire history is listed
+func unreleasedCommits(ctx context.Context, ctr *dagger.Container, vargs versionArgs, format string) (string, error) {
+	describe := []string{"git", "describe", "--tags", "--abbrev=0"}
+	if len(vargs.Paths) == 1 {
+		describe = append(describe, "--match", path.Base(vargs.Paths[0])+"/*")
+	}
+
+	cmd := []string{"git", "log", "--format=" + format}
+	if latest, err := ctr.WithExec(describe).Stdout(ctx); err == nil {
+		cmd = append(cmd, strings.TrimSpace(latest)+"..HEAD")
+	}
+
+	pathspecs := vargs.Paths
+	if len(pathspecs) == 0 && len(vargs.ExcludePaths) > 0 {
+		pathspecs = []string{"."}
+	}
+
+	for _, p := range vargs.ExcludePaths {
+		pathspecs = append(pathspecs, ":(exclude)"+p)
+	}
+
+	if len(pathspecs) > 0 {
+		cmd = append(cmd, "--")
+		cmd = append(cmd, pathspecs...)
+	}
+
+	return ctr.WithExec(cmd).Stdout(ctx)
+}
+
+const (
+	excludedCommitsDir    = "/tmp/nsv/excluded-commits"
+	excludedCommitMessage = "excluded from semantic versioning"
+)
+
+// Ignores any commit since the latest tag that only changes excluded paths, by replacing it
+// with an identical commit that has a non-conventional message, through git replace. As the
+// tree and parents are unchanged, nsv calculates the next semantic version from the remaining
+// commits, while any tag or commit it creates is still based on the original history. When
+// analyzing multiple paths, a commit is only ignored if it changes no path outside of the
+// excluded paths for any of them
+func ignoreExcludedChanges(ctx context.Context, ctr *dagger.Container, vargs versionArgs) (*dagger.Container, error) {
+	if len(vargs.ExcludePaths) == 0 {
+		return ctr, nil
+	}
+
+	paths := [][]string{nil}
+	if len(vargs.Paths) > 0 {
+		paths = nil
+		for _, p := range vargs.Paths {
+			paths = append(paths, []string{p})
+		}
+	}
+
+	var included, unreleased []string
+	for _, p := range paths {
+		vargs.Paths = p
+		out, err := unreleasedCommits(ctx, ctr, vargs, "%H")
+		if err != nil {
+			return nil, err
+		}
+		included = append(included, strings.Fields(out)...)
+
+		all := vargs
+		all.ExcludePaths = nil
+		if out, err = unreleasedCommits(ctx, ctr, all, "%H"); err != nil {
+			return nil, err
+		}
+		unreleased = append(unreleased, strings.Fields(out)...)
+	}
+
+	slices.Sort(unreleased)
+	for _, commit := range slices.Compact(unreleased) {
+		if slices.Contains(included, commit) {
+			continue
+		}
+
+		raw, err := ctr.WithExec([]string{"git", "cat-file", "commit", commit}).Stdout(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		header, _, _ := strings.Cut(raw, "\n\n")
+		replacement := path.Join(excludedCommitsDir, commit)
+		ctr = ctr.WithNewFile(replacement, header+"\n\n"+excludedCommitMessage+"\n")
+
+		replaced, err := ctr.WithExec([]string{"git", "hash-object", "-t", "commit", "-w", replacement}).Stdout(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		ctr = ctr.WithExec([]string{"git", "replace", "-f", commit, strings.TrimSpace(replaced)})
+	}
+
+	return ctr, nil
+}
+
+// Checks if a conventional commit prefix, or its type without a scope, matches any
+// of the configured prefixes
+func matchesPrefix(prefix, typ string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if p == prefix || p == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// Renders the tag message template, falling back to the tag itself if empty
+func renderTagMessage(tagMessage, tag string) (string, error) {
+	tmpl, err := template.New("message").Option("missingkey=zero").Parse(tagMessage)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse tag message template: %w", err)
+	}
+
+	var msg strings.Builder
+	if err := tmpl.Execute(&msg, map[string]string{"Tag": tag}); err != nil {
+		return "", err
+	}
+
+	if msg.Len() == 0 {
+		return tag, nil
+	}
+	return msg.String(), nil
+}
+
+// Validates that a tag is a valid git ref name, following the rules of git check-ref-format
+func validateTagName(tag string) error {
+	invalid := func(reason string) error {
+		return fmt.Errorf("invalid tag '%s', %s", tag, reason)
+	}
+
+	if tag == "@" {
+		return invalid("cannot be the single character @")
+	}
+
+	if strings.HasPrefix(tag, "-") {
+		return invalid("cannot begin with a hyphen")
+	}
+
+	if strings.HasPrefix(tag, "/") || strings.HasSuffix(tag, "/") || strings.Contains(tag, "//") {
+		return invalid("cannot begin or end with a slash, or contain consecutive slashes")
+	}
+
+	if strings.HasSuffix(tag, ".") {
+		return invalid("cannot end with a dot")
+	}
+
+	if strings.Contains(tag, "..") {
+		return invalid("cannot contain consecutive dots")
+	}
+
+	if strings.Contains(tag, "@{") {
+		return invalid("cannot contain the sequence @{")
+	}
+
+	for _, r := range tag {
+		if isInvalidRefChar(r) {
+			return invalid(fmt.Sprintf("contains the invalid character %q", r))
+		}
+	}
+
+	for _, component := range strings.Split(tag, "/") {
+		if strings.HasPrefix(component, ".") || strings.HasSuffix(component, ".lock") {
+			return invalid("path components cannot begin with a dot or end with .lock")
+		}
+	}
+
+	return nil
+}
+
+// Sanitizes a tag into a valid git ref name, replacing any invalid characters with a hyphen
+func sanitizeTagName(tag string) string {
+	var buf strings.Builder
+	for _, r := range tag {
+		if isInvalidRefChar(r) {
+			buf.WriteRune('-')
+			continue
+		}
+		buf.WriteRune(r)
+	}
+
+	sanitized := strings.ReplaceAll(buf.String(), "@{", "-{")
+	for strings.Contains(sanitized, "..") {
+		sanitized = strings.ReplaceAll(sanitized, "..", ".")
+	}
+
+	var components []string
+	for _, component := range strings.Split(sanitized, "/") {
+		component = strings.TrimLeft(component, ".")
+		if lock, found := strings.CutSuffix(component, ".lock"); found {
+			component = lock + "-lock"
+		}
+
+		if component != "" {
+			components = append(components, component)
+		}
+	}
+
+	sanitized = strings.TrimLeft(strings.TrimRight(strings.Join(components, "/"), "."), "-")
+	if sanitized == "@" {
+		return "-"
+	}
+	return sanitized
+}
+
+func isInvalidRefChar(r rune) bool {
+	return r < 0x20 || r == 0x7f || strings.ContainsRune(" ~^:?*[\\", r)
+}
+
+// Tags each project within a monorepo independently. The next semantic version of each
+// project is calculated by nsv, with the prefix nsv generates for its path replaced by the
+// rendered tag prefix. All tags are then created and pushed using git directly
+func monorepoTag(
+	ctx context.Context,
+	ctr *dagger.Container,
+	annotated bool,
+	tagMessage string,
+	tagPrefix string,
+	sanitize bool,
+	vargs versionArgs,
+) (string, error) {
+	if len(vargs.Paths) == 0 {
+		return "", fmt.Errorf("at least one path must be provided when tagging a monorepo")
+	}
+
+	prefixes, err := renderTagPrefixes(tagPrefix, vargs.Paths)
+	if err != nil {
+		return "", err
+	}
+
+	// Only the calculated version should be printed
+	vargs.Show = false
+	paths := vargs.Paths
+
+	var tags []string
+	for i, p := range paths {
+		vargs.Paths = []string{p}
+
+		// nsv only recognizes tags using its own prefix when finding the latest version
+		nsvPrefix := path.Base(p) + "/"
+		calc, err := withTagAliases(ctx, ctr, prefixes[i], nsvPrefix)
+		if err != nil {
+			return "", err
+		}
+
+		cmd := append([]string{"next"}, vargs.args()...)
+		out, err := calc.WithExec(cmd, dagger.ContainerWithExecOpts{UseEntrypoint: true}).Stdout(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		version := strings.TrimPrefix(strings.TrimSpace(out), nsvPrefix)
+		if version == "" {
+			continue
+		}
+
+		if sanitize {
+			version = sanitizeTagName(version)
+		}
+
+		tag := prefixes[i] + version
+		if err := validateTagName(tag); err != nil {
+			return "", err
+		}
+
+		tagCmd := []string{"git", "tag", tag}
+		if annotated {
+			msg, err := renderTagMessage(tagMessage, tag)
+			if err != nil {
+				return "", err
+			}
+			tagCmd = []string{"git", "tag", "-a", tag, "-m", msg}
+		}
+
+		ctr = ctr.WithExec(tagCmd)
+		tags = append(tags, tag)
+	}
+
+	if len(tags) == 0 {
+		return "", nil
+	}
+
+	_, err = ctr.
+		WithExec(append([]string{"git", "push", "origin"}, tags...)).
+		Sync(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join(tags, "\n"), nil
+}
+
+// Renders the tag prefix template for each path within a monorepo. Every prefix must
+// be unique and form a valid git ref name, as it cannot be sanitized without breaking
+// the lookup of any existing tags
+func renderTagPrefixes(tagPrefix string, paths []string) ([]string, error) {
+	tmpl, err := template.New("prefix").Option("missingkey=error").Parse(tagPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse tag prefix template: %w", err)
+	}
+
+	var prefixes []string
+	for _, p := range paths {
+		var prefix strings.Builder
+		if err := tmpl.Execute(&prefix, map[string]string{
+			"Path": strings.Trim(p, "/"),
+			"Name": path.Base(p),
+		}); err != nil {
+			return nil, fmt.Errorf("failed to render tag prefix template: %w", err)
+		}
+
+		if prefix.Len() == 0 {
+			return nil, fmt.Errorf("tag prefix for path %s is empty", p)
+		}
+
+		if err := validateTagName(prefix.String() + "0.0.0"); err != nil {
+			return nil, fmt.Errorf("invalid tag prefix %s for path %s: %w", prefix.String(), p, err)
+		}
+
+		if slices.Contains(prefixes, prefix.String()) {
+			return nil, fmt.Errorf("tag prefix %s is not unique to path %s", prefix.String(), p)
+		}
+		prefixes = append(prefixes, prefix.String())
+	}
+
+	return prefixes, nil
+}
+
+// Creates a local alias for every tag using the given prefix, replacing it with the target
+// prefix. Aliases are never pushed, and only allow nsv to find the latest tag of a project
+func withTagAliases(ctx context.Context, ctr *dagger.Container, prefix, target string) (*dagger.Container, error) {
+	if prefix == target {
+		return ctr, nil
+	}
+
+	// A lightweight tag has no peeled object name, so the commit is always the second field
+	out, err := ctr.WithExec([]string{"git", "for-each-ref", "--format=%(refname:lstrip=2) %(*objectname) %(objectname)", "refs/tags"}).
+		Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var updates strings.Builder
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.HasPrefix(fields[0], prefix) {
+			continue
+		}
+
+		fmt.Fprintf(&updates, "update refs/tags/%s%s %s\n", target, strings.TrimPrefix(fields[0], prefix), fields[1])
+	}
+
+	if updates.Len() == 0 {
+		return ctr, nil
+	}
+
+	return ctr.WithExec([]string{"git", "update-ref", "--stdin"}, dagger.ContainerWithExecOpts{Stdin: updates.String()}), nil
+}
+
+const versionFilesDir = "/tmp/nsv/version-files"
+
+// Built-in patterns for locating the version within common version files, keyed
+// by file extension. The first capture group contains the version
+var versionFilePatterns = map[string]*regexp.Regexp{
+	".toml": regexp.MustCompile(`(?m)^version\s*=\s*"([^"]*)"`),
+	".json": regexp.MustCompile(`"version"\s*:\s*"([^"]*)"`),
 }
 
-func configureGPG(base *dagger.Container, privateKey, passphrase *dagger.Secret) *dagger.Container {
+var semverSuffix = regexp.MustCompile(`(\d+\.\d+\.\d+(?:[-+][0-9A-Za-z.+-]*)?)$`)
+
+// Patches each version file with the next semantic version. Patched files are staged
+// outside of the repository and copied into place by extending the hook, ensuring nsv
+// commits them alongside any changes made by a user-defined hook
+func withVersionFiles(ctx context.Context, ctr *dagger.Container, hook string, tags []string, vargs versionArgs) (*dagger.Container, string, error) {
+	if len(vargs.VersionFiles) == 0 {
+		return ctr, hook, nil
+	}
+
+	if len(vargs.Paths) > 1 {
+		return nil, "", fmt.Errorf("version files cannot be patched when analyzing multiple paths")
+	}
+
+	var pattern *regexp.Regexp
+	if vargs.VersionPattern != "" {
+		var err error
+		if pattern, err = regexp.Compile(vargs.VersionPattern); err != nil {
+			return nil, "", fmt.Errorf("invalid version pattern: %w", err)
+		}
+
+		if pattern.NumSubexp() == 0 {
+			return nil, "", fmt.Errorf("version pattern %s must contain a capture group", vargs.VersionPattern)
+		}
+	}
+
+	if len(tags) == 0 {
+		return ctr, hook, nil
+	}
+
+	version := semverSuffix.FindString(tags[0])
+	if version == "" {
+		return nil, "", fmt.Errorf("unable to extract a semantic version from tag %s", tags[0])
+	}
+
+	var cmds []string
+	if hook != "" {
+		cmds = append(cmds, hook)
+	}
+
+	for _, file := range vargs.VersionFiles {
+		contents, err := ctr.File(file).Contents(ctx)
+		if err != nil {
+			return nil, "", err
+		}
+
+		patched, err := patchVersion(file, contents, version, pattern)
+		if err != nil {
+			return nil, "", err
+		}
+
+		staged := path.Join(versionFilesDir, file)
+		ctr = ctr.WithNewFile(staged, patched)
+		cmds = append(cmds, fmt.Sprintf("cp '%s' '%s'", staged, file))
+	}
+
+	return ctr, strings.Join(cmds, " && "), nil
+}
+
+// Replaces the first capture group of the first match of the pattern with the version. If
+// no pattern is provided, a built-in pattern is selected by file extension, with the entire
+// file being replaced if the extension is not recognized
+func patchVersion(file, contents, version string, pattern *regexp.Regexp) (string, error) {
+	if pattern == nil {
+		var ok bool
+		if pattern, ok = versionFilePatterns[path.Ext(file)]; !ok {
+			return version + "\n", nil
+		}
+	}
+
+	loc := pattern.FindStringSubmatchIndex(contents)
+	if loc == nil || loc[2] < 0 {
+		return "", fmt.Errorf("no version found within %s", file)
+	}
+
+	return contents[:loc[2]] + version + contents[loc[3]:], nil
+}
+
+// Exposes the next semantic version to a user-defined hook through the NSV_NEXT_TAG
+// environment variable. When analyzing multiple paths, the variable contains a space
+// separated list of tags, one for each path. As tags are validated as git ref names,
+// they never contain spaces
+func withHookEnv(ctr *dagger.Container, hook string, tags []string) *dagger.Container {
+	if hook == "" {
+		return ctr
+	}
+
+	return ctr.WithEnvVariable("NSV_NEXT_TAG", strings.Join(tags, " "))
+}
+
+// Prepares the repository for calculating the next semantic version, optionally fetching
+// all tags from the remote, and ignoring any changes to excluded paths. A warning is returned
+// if the history of the repository is incomplete, as the next semantic version may be
+// calculated from the wrong base version
+func prepareHistory(ctx context.Context, ctr *dagger.Container, vargs versionArgs) (*dagger.Container, string, error) {
+	if vargs.FetchTags {
+		ctr = ctr.WithExec([]string{"git", "fetch", "--tags", "--force", "origin"})
+	}
+
+	shallow, err := ctr.WithExec([]string{"git", "rev-parse", "--is-shallow-repository"}).Stdout(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	tags, err := ctr.WithExec([]string{"git", "tag", "--list"}).Stdout(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var warnings []string
+	if strings.TrimSpace(shallow) == "true" && !vargs.FixShallow {
+		warnings = append(warnings, "the repository is a shallow clone and its commit history is incomplete, enable fixShallow to fetch the full history")
+	}
+
+	if strings.TrimSpace(tags) == "" && !vargs.FetchTags {
+		warnings = append(warnings, "no tags exist within the repository, if this is unexpected enable fetchTags to fetch them from the remote")
+	}
+
+	if ctr, err = ignoreExcludedChanges(ctx, ctr, vargs); err != nil {
+		return nil, "", err
+	}
+
+	if len(warnings) == 0 {
+		return ctr, "", nil
+	}
+
+	return ctr, "the next semantic version may be calculated from an incomplete history: " + strings.Join(warnings, "; "), nil
+}
+
+// Appends a warning to the output of nsv, ensuring it is visible to the caller
+func withWarning(out, warning string) string {
+	if warning == "" {
+		return out
+	}
+
+	return fmt.Sprintf("%s\nwarning: %s\n", strings.TrimRight(out, "\n"), warning)
+}
+
+func configureGitIdentity(base *dagger.Container, name, email string) *dagger.Container {
+	ctr := base
+	if name != "" {
+		ctr = ctr.WithExec([]string{"git", "config", "user.name", name})
+	}
+
+	if email != "" {
+		ctr = ctr.WithExec([]string{"git", "config", "user.email", email})
+	}
+
+	return ctr
+}
+
+func configureGPG(base *dagger.Container, privateKey, passphrase *dagger.Secret, signCommits bool) *dagger.Container {
 	ctr := base
 	if privateKey != nil {
 		ctr = ctr.WithSecretVariable("GPG_PRIVATE_KEY", privateKey).
 			WithEnvVariable("GPG_TRUST_LEVEL", "5")
+
+		if signCommits {
+			ctr = ctr.WithExec([]string{"git", "config", "commit.gpgsign", "true"})
+		}
 	}
 
 	if passphrase != nil {
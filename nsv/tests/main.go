@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"dagger/tests/internal/dagger"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/sourcegraph/conc/pool"
+)
+
+const gitImage = "alpine:3.20"
+
+type Tests struct{}
+
+func (m *Tests) AllTests(ctx context.Context) error {
+	p := pool.New().WithErrors().WithContext(ctx)
+
+	p.Go(m.TagMonorepo)
+	p.Go(m.TagMonorepoPrefix)
+	p.Go(m.NextExcludePaths)
+
+	return p.Wait()
+}
+
+// A git repository containing a single project within a monorepo. The remote
+// origin is a bare repository within the .git directory, allowing tags to be pushed
+func monorepo() *dagger.Container {
+	return dag.Container().
+		From(gitImage).
+		WithExec([]string{"apk", "add", "--no-cache", "git"}).
+		WithWorkdir("/repo").
+		WithExec([]string{"sh", "-c", strings.Join([]string{
+			"git init -q -b main",
+			"git config user.name tests",
+			"git config user.email tests@example.com",
+			"git init -q --bare .git/origin",
+			"git remote add origin .git/origin",
+			"mkdir -p services/api",
+			"echo api > services/api/main.txt",
+			"git add .",
+			"git commit -q -m 'feat: initial api'",
+		}, " && ")})
+}
+
+func (m *Tests) TagMonorepo(ctx context.Context) error {
+	repo := monorepo()
+	opts := dagger.NsvTagOpts{
+		Monorepo: true,
+		Paths:    []string{"services/api"},
+	}
+
+	first, err := dag.Nsv(repo.Directory("/repo")).Tag(ctx, opts)
+	if err != nil {
+		return err
+	}
+	first = strings.TrimSpace(first)
+
+	if first == "" {
+		return fmt.Errorf("expected the project to be tagged")
+	}
+
+	// Mirror the tag that was pushed to the remote and make a further change to the project
+	repo = repo.WithExec([]string{"sh", "-c", strings.Join([]string{
+		fmt.Sprintf("git tag %s", first),
+		"echo fix >> services/api/main.txt",
+		"git commit -q -am 'fix: patch api'",
+	}, " && ")})
+
+	second, err := dag.Nsv(repo.Directory("/repo")).Tag(ctx, opts)
+	if err != nil {
+		return err
+	}
+	second = strings.TrimSpace(second)
+
+	if second == first {
+		return fmt.Errorf("expected the next tag to follow %s, but the same tag was calculated", first)
+	}
+
+	if path.Dir(second) != path.Dir(first) {
+		return fmt.Errorf("expected the next tag %s to retain the prefix of %s", second, first)
+	}
+
+	return nil
+}
+
+func (m *Tests) TagMonorepoPrefix(ctx context.Context) error {
+	repo := monorepo()
+	opts := dagger.NsvTagOpts{
+		Monorepo:  true,
+		Paths:     []string{"services/api"},
+		TagPrefix: "releases/{{.Name}}-",
+	}
+
+	first, err := dag.Nsv(repo.Directory("/repo")).Tag(ctx, opts)
+	if err != nil {
+		return err
+	}
+	first = strings.TrimSpace(first)
+
+	if !strings.HasPrefix(first, "releases/api-") {
+		return fmt.Errorf("expected the tag %s to use the prefix releases/api-", first)
+	}
+
+	// Mirror the tag that was pushed to the remote and make a further change to the project
+	repo = repo.WithExec([]string{"sh", "-c", strings.Join([]string{
+		fmt.Sprintf("git tag %s", first),
+		"echo fix >> services/api/main.txt",
+		"git commit -q -am 'fix: patch api'",
+	}, " && ")})
+
+	second, err := dag.Nsv(repo.Directory("/repo")).Tag(ctx, opts)
+	if err != nil {
+		return err
+	}
+	second = strings.TrimSpace(second)
+
+	if second == first {
+		return fmt.Errorf("expected the next tag to follow %s, but the same tag was calculated", first)
+	}
+
+	if !strings.HasPrefix(second, "releases/api-") {
+		return fmt.Errorf("expected the next tag %s to use the prefix releases/api-", second)
+	}
+
+	return nil
+}
+
+func (m *Tests) NextExcludePaths(ctx context.Context) error {
+	repo := dag.Container().
+		From(gitImage).
+		WithExec([]string{"apk", "add", "--no-cache", "git"}).
+		WithWorkdir("/repo").
+		WithExec([]string{"sh", "-c", strings.Join([]string{
+			"git init -q -b main",
+			"git config user.name tests",
+			"git config user.email tests@example.com",
+			"echo app > main.txt",
+			"git add .",
+			"git commit -q -m 'feat: initial app'",
+			"git tag v0.1.0",
+			"mkdir docs",
+			"echo docs > docs/index.md",
+			"git add .",
+			"git commit -q -m 'feat: document the app'",
+			"echo fix >> main.txt",
+			"git commit -q -am 'fix: patch app'",
+		}, " && ")})
+
+	out, err := dag.Nsv(repo.Directory("/repo")).Next(ctx, dagger.NsvNextOpts{
+		ExcludePaths: []string{"docs"},
+	})
+	if err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(out) != "v0.1.1" {
+		return fmt.Errorf("expected a patch increment to v0.1.1 as the feature only changed excluded paths, but got %s", out)
+	}
+
+	return nil
+}
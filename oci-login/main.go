@@ -14,10 +14,19 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"dagger/oci-login/internal/dagger"
 )
 
+const (
+	AwsCliImage        = "amazon/aws-cli:2.18.0"
+	AwsCredentialsFile = "/root/.aws/credentials"
+	ecrUsername        = "AWS"
+)
+
 // When mapped to a JSON file provides a way to control authenticate to an Image
 // Registry, https://github.com/containers/image/blob/main/docs/containers-auth.json.5.md
 type ContainerAuth struct {
@@ -72,9 +81,82 @@ func (m *OciLogin) WithAuth(
 	return m, nil
 }
 
+// Configure credentials for authenticating to an AWS ECR registry. An authorization token is
+// retrieved through the AWS API using the AWS CLI, replicating aws ecr get-login-password.
+// As a token expires after 12 hours, a new token is retrieved every time this is called.
+// Can be chained to configure multiple credentials in a single pass
+//
+// Examples:
+//
+// # Generate a config for the ECR registry of the authenticated AWS account
+// $ dagger call with-ecr --region eu-west-2 --aws-creds file:$HOME/.aws/credentials as-config
+func (m *OciLogin) WithEcr(
+	ctx context.Context,
+	// the AWS region of the ECR registry (e.g. eu-west-2)
+	// +required
+	region string,
+	// an AWS shared credentials file, containing the access key used to authenticate with AWS
+	// +required
+	awsCreds *dagger.Secret,
+	// the named profile within the AWS shared credentials file
+	// +optional
+	// +default="default"
+	profile string,
+	// the ID of the AWS account that owns the ECR registry, defaults to the account of
+	// the authenticated AWS user
+	// +optional
+	accountId string,
+) (*OciLogin, error) {
+	aws := dag.Container().
+		From(AwsCliImage).
+		WithMountedSecret(AwsCredentialsFile, awsCreds).
+		WithEnvVariable("AWS_PROFILE", profile).
+		WithEnvVariable("AWS_REGION", region).
+		// A token expires, so must never be retrieved from the dagger cache
+		WithEnvVariable("DAGGER_CACHE_BUSTER", strconv.FormatInt(time.Now().UnixNano(), 10))
+
+	if accountId == "" {
+		out, err := aws.
+			WithExec([]string{"sts", "get-caller-identity", "--query", "Account", "--output", "text"},
+				dagger.ContainerWithExecOpts{UseEntrypoint: true}).
+			Stdout(ctx)
+		if err != nil {
+			return nil, err
+		}
+		accountId = strings.TrimSpace(out)
+	}
+
+	// Avoid the token being captured within the output of the exec
+	token, err := aws.
+		WithExec([]string{"ecr", "get-login-password"}, dagger.ContainerWithExecOpts{
+			UseEntrypoint:  true,
+			RedirectStdout: "/tmp/ecr-token",
+		}).
+		File("/tmp/ecr-token").
+		Contents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	hostname := fmt.Sprintf("%s.dkr.ecr.%s.amazonaws.com", accountId, region)
+	str := fmt.Sprintf("%s:%s", ecrUsername, strings.TrimSpace(token))
+
+	m.Config.Auths[hostname] = Auth{
+		Auth: base64.StdEncoding.EncodeToString([]byte(str)),
+	}
+	return m, nil
+}
+
 // Generates a JSON representation of the current OCI login configuration as a file
-func (m *OciLogin) AsConfig() *dagger.File {
+func (m *OciLogin) AsConfig(
+	// pretty-print the generated JSON using a two-space indentation
+	// +optional
+	indent bool,
+) *dagger.File {
 	config, _ := json.Marshal(m.Config)
+	if indent {
+		config, _ = json.MarshalIndent(m.Config, "", "  ")
+	}
 
 	return dag.Directory().
 		WithNewFile("oci-config.json", string(config), dagger.DirectoryWithNewFileOpts{Permissions: 0o644}).
@@ -4,15 +4,32 @@ package main
 
 import (
 	"context"
+	"crypto/md5"
 	"dagger/rust/internal/dagger"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
-	rustWorkDir = "/src"
+	rustWorkDir       = "/src"
+	cacheBusterEnv    = "DAGGER_CACHE_BUSTER"
+	rustTargetDir     = "/src/target"
+	wasmUnknownTarget = "wasm32-unknown-unknown"
+	muslTarget        = "x86_64-unknown-linux-musl"
+	alpineImage       = "alpine:3.20.3"
+	wasmtimeVersion   = "v26.0.1"
+	wasmtimeBin       = "/usr/local/bin/wasmtime"
 
 	CargoRegistryCache = "/root/.cargo/registry"
 	CargoGitCache      = "/root/.cargo/git"
+	CargoConfig        = "/root/.cargo/config.toml"
+	CargoCredentials   = "/root/.cargo/credentials.toml"
+	SccacheDir         = "/root/.cache/sccache"
 	RustGithubRepo     = "rust-lang/rust"
 	RustBaseImage      = "rust"
 )
@@ -26,6 +43,25 @@ type Rust struct {
 	// a path to a directory containing the projects source code
 	// +private
 	Src *dagger.Directory
+
+	// a list of private registries that cargo can authenticate with
+	// +private
+	Registries []CargoRegistry
+}
+
+// A private registry that cargo can authenticate with when downloading crates
+type CargoRegistry struct {
+	// the name of the registry, as referenced by dependencies within a Cargo.toml file
+	// +private
+	Name string
+
+	// the URL of the registry index
+	// +private
+	Index string
+
+	// the token used to authenticate with the registry
+	// +private
+	Token *dagger.Secret
 }
 
 // Initializes the rust dagger module
@@ -93,6 +129,71 @@ func mountCaches(ctx context.Context, base *dagger.Container) *dagger.Container
 		WithMountedCache(CargoGitCache, cargoGit)
 }
 
+// Enable support for downloading crates from a private registry. The registry is configured
+// within the cargo config.toml file, while its token is written to a credentials.toml file
+// that is mounted as a secret. Each call will configure an additional registry
+//
+// Examples:
+//
+// # Configure a private sparse registry
+// $ dagger call with-private --registry internal --index sparse+https://crates.example.com/index/ --token env:CARGO_TOKEN
+func (r *Rust) WithPrivate(
+	ctx context.Context,
+	// the name of the registry, as referenced by dependencies within a Cargo.toml file
+	// +required
+	registry string,
+	// the URL of the registry index, prefixed with sparse+ for a sparse registry
+	// +required
+	index string,
+	// the token used to authenticate with the registry
+	// +required
+	token *dagger.Secret,
+) (*Rust, error) {
+	r.Registries = append(r.Registries, CargoRegistry{Name: registry, Index: index, Token: token})
+
+	var config, credentials strings.Builder
+	for _, reg := range r.Registries {
+		tkn, err := reg.Token.Plaintext(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		fmt.Fprintf(&config, "[registries.%s]\nindex = %q\n\n", reg.Name, reg.Index)
+		fmt.Fprintf(&credentials, "[registries.%s]\ntoken = %q\n\n", reg.Name, tkn)
+	}
+
+	hash := md5.Sum([]byte(credentials.String()))
+	secret := dag.SetSecret(fmt.Sprintf("cargo-credentials-%s", hex.EncodeToString(hash[:])), credentials.String())
+
+	r.Base = r.Base.
+		WithNewFile(CargoConfig, config.String(), dagger.ContainerWithNewFileOpts{Permissions: 0o644}).
+		WithMountedSecret(CargoCredentials, secret)
+	return r, nil
+}
+
+// Enable sccache as a compiler wrapper, caching compiled crates within a shared cache volume
+// that persists across pipeline runs. Significantly reduces the time of subsequent builds.
+// Incremental compilation is disabled, as it is not supported by sccache
+//
+// Examples:
+//
+// # Build a project with sccache enabled
+// $ dagger call --src . with-sccache build
+func (r *Rust) WithSccache(ctx context.Context) (*Rust, error) {
+	ctr := r.Base
+	if _, err := ctr.WithExec([]string{"sccache", "--version"}).Sync(ctx); err != nil {
+		// Prefer the prebuilt package over compiling sccache from source
+		ctr = ctr.WithExec([]string{"sh", "-c", "apk add --no-cache sccache || cargo install sccache --locked"})
+	}
+
+	r.Base = ctr.
+		WithMountedCache(SccacheDir, dag.CacheVolume("sccache")).
+		WithEnvVariable("SCCACHE_DIR", SccacheDir).
+		WithEnvVariable("RUSTC_WRAPPER", "sccache").
+		WithEnvVariable("CARGO_INCREMENTAL", "0")
+	return r, nil
+}
+
 // Lint your Rust project with Clippy to detect common mistakes and to improve
 // your Rust code
 func (r *Rust) Clippy(
@@ -100,13 +201,24 @@ func (r *Rust) Clippy(
 	// run clippy on the current crate only and not against its dependencies
 	// +optional
 	noDeps bool,
+	// only select the package with the given name within a workspace, through cargo -p
+	// +optional
+	pkg string,
+	// select all packages within a workspace, through cargo --workspace
+	// +optional
+	workspace bool,
 ) (string, error) {
+	selection, err := packageSelection(pkg, workspace)
+	if err != nil {
+		return "", err
+	}
+
 	ctr := r.Base
 	if _, err := ctr.WithExec([]string{"cargo", "clippy", "--version"}).Sync(ctx); err != nil {
 		ctr = ctr.WithExec([]string{"rustup", "component", "add", "clippy"})
 	}
 
-	cmd := []string{"cargo", "clippy"}
+	cmd := append([]string{"cargo", "clippy"}, selection...)
 	if noDeps {
 		cmd = append(cmd, "--no-deps")
 	}
@@ -126,13 +238,592 @@ func (r *Rust) Format(ctx context.Context) (*dagger.Directory, error) {
 }
 
 // Checks the format of the code in your Rust project using Rustfmt. Fails
-// if any formatting issues are detected
-func (r *Rust) FormatCheck(ctx context.Context) (string, error) {
+// if any formatting issues are detected. All packages within a workspace are
+// checked, unless a single package is selected
+func (r *Rust) FormatCheck(
+	ctx context.Context,
+	// only select the package with the given name within a workspace, through cargo -p
+	// +optional
+	pkg string,
+) (string, error) {
 	ctr := r.Base
 	if _, err := ctr.WithExec([]string{"cargo", "fmt", "--version"}).Sync(ctx); err != nil {
 		ctr = ctr.WithExec([]string{"rustup", "component", "add", "rustfmt"})
 	}
 
-	cmd := []string{"cargo", "fmt", "--all", "--", "--check"}
+	cmd := []string{"cargo", "fmt", "--all"}
+	if pkg != "" {
+		cmd = []string{"cargo", "fmt", "-p", pkg}
+	}
+	cmd = append(cmd, "--", "--check")
 	return ctr.WithExec(cmd).Stdout(ctx)
 }
+
+// The JSON report generated by cargo-outdated
+type outdatedReport struct {
+	CrateName    string               `json:"crate_name"`
+	Dependencies []outdatedDependency `json:"dependencies"`
+}
+
+type outdatedDependency struct {
+	Name    string `json:"name"`
+	Project string `json:"project"`
+	Latest  string `json:"latest"`
+}
+
+// Checks your Rust project for dependencies with newer versions available using
+// cargo-outdated. Optionally fails if any dependency is behind by a major version
+func (r *Rust) Outdated(
+	ctx context.Context,
+	// fail if any dependency is behind by a major (semver incompatible) version
+	// +optional
+	failOnMajor bool,
+	// only check the direct dependencies of the current crate
+	// +optional
+	rootDepsOnly bool,
+) (string, error) {
+	ctr := r.Base
+	if _, err := ctr.WithExec([]string{"cargo", "outdated", "--version"}).Sync(ctx); err != nil {
+		ctr = ctr.WithExec([]string{"cargo", "install", "cargo-outdated", "--locked"})
+	}
+
+	cmd := []string{"cargo", "outdated"}
+	if rootDepsOnly {
+		cmd = append(cmd, "--root-deps-only")
+	}
+
+	out, err := ctr.WithExec(cmd).Stdout(ctx)
+	if err != nil || !failOnMajor {
+		return out, err
+	}
+
+	report, err := ctr.WithExec(append(cmd, "--format", "json")).Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var behind []string
+	dec := json.NewDecoder(strings.NewReader(report))
+	for dec.More() {
+		var crate outdatedReport
+		if err := dec.Decode(&crate); err != nil {
+			return "", err
+		}
+
+		for _, dep := range crate.Dependencies {
+			if isMajorBehind(dep.Project, dep.Latest) {
+				behind = append(behind, fmt.Sprintf("%s (%s -> %s)", dep.Name, dep.Project, dep.Latest))
+			}
+		}
+	}
+
+	if len(behind) > 0 {
+		return "", fmt.Errorf("dependencies are behind by a major version:\n%s\n\n%s",
+			strings.Join(behind, "\n"), out)
+	}
+
+	return out, nil
+}
+
+// Updates the dependencies of your Rust project within the Cargo.lock file to their latest
+// compatible versions using cargo update, returning the updated Cargo.lock file. The update
+// is always executed, ensuring the latest versions are resolved on every run
+//
+// Examples:
+//
+// # Update all dependencies and export the updated lockfile
+// $ dagger call --src . update export --path Cargo.lock
+//
+// # Update a single dependency to a precise version
+// $ dagger call --src . update --pkg serde --precise 1.0.210 export --path Cargo.lock
+func (r *Rust) Update(
+	// a list of dependencies to update, through cargo update -p. All dependencies
+	// are updated if none are provided
+	// +optional
+	pkg []string,
+	// update a single dependency to a precise version, through cargo update --precise
+	// +optional
+	precise string,
+) (*dagger.File, error) {
+	if precise != "" && len(pkg) != 1 {
+		return nil, fmt.Errorf("a precise version can only be used when updating a single dependency")
+	}
+
+	cmd := []string{"cargo", "update"}
+	for _, p := range pkg {
+		cmd = append(cmd, "-p", p)
+	}
+
+	if precise != "" {
+		cmd = append(cmd, "--precise", precise)
+	}
+
+	return r.Base.
+		WithEnvVariable(cacheBusterEnv, strconv.FormatInt(time.Now().UnixNano(), 10)).
+		WithExec(cmd).
+		File(path.Join(rustWorkDir, "Cargo.lock")), nil
+}
+
+// Follows the cargo interpretation of semver, where the left-most non-zero
+// component of a version identifies compatibility
+func isMajorBehind(current, latest string) bool {
+	cur := strings.Split(current, ".")
+	lat := strings.Split(latest, ".")
+	if len(cur) < 2 || len(lat) < 2 {
+		return false
+	}
+
+	for i := 0; i < len(cur) && i < len(lat) && i < 3; i++ {
+		c, err := strconv.Atoi(cur[i])
+		if err != nil {
+			return false
+		}
+
+		l, err := strconv.Atoi(lat[i])
+		if err != nil {
+			return false
+		}
+
+		if c != l {
+			return l > c
+		}
+
+		if c != 0 {
+			return false
+		}
+	}
+
+	return false
+}
+
+// Build your Rust project, returning the target directory containing all build
+// artifacts. Targets are installed on demand through rustup. WebAssembly is supported
+// out of the box through the wasm32-unknown-unknown and wasm32-wasip1 (or wasm32-wasi
+// for older toolchains) targets
+func (r *Rust) Build(
+	ctx context.Context,
+	// build artifacts in release mode, with optimizations
+	// +optional
+	// +default=true
+	release bool,
+	// the target triple to build for (e.g. wasm32-wasip1), defaults to the host
+	// +optional
+	target string,
+	// only select the package with the given name within a workspace, through cargo -p
+	// +optional
+	pkg string,
+	// select all packages within a workspace, through cargo --workspace
+	// +optional
+	workspace bool,
+) (*dagger.Directory, error) {
+	selection, err := packageSelection(pkg, workspace)
+	if err != nil {
+		return nil, err
+	}
+
+	ctr, err := withTarget(ctx, r.Base, target)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := append([]string{"cargo", "build"}, selection...)
+	if release {
+		cmd = append(cmd, "--release")
+	}
+
+	if target != "" {
+		cmd = append(cmd, "--target", target)
+	}
+
+	return ctr.WithExec(cmd).Directory(rustTargetDir), nil
+}
+
+// Build a statically linked binary from your Rust project and package it as the entrypoint
+// of a minimal container image. By default the binary is built for a musl target and copied
+// into an empty (scratch) image. The container can be published or exported as a tarball.
+// When cross-compiling for a different architecture, the binary is linked using rust-lld,
+// which is bundled with the toolchain. Crates that compile C code still require a C cross
+// compiler within the base image
+//
+// Examples:
+//
+// # Build a container image for the server binary and export it as a tarball
+// $ dagger call container --bin server export --path server.tar
+//
+// # Build a container image for an arm64 binary on top of a distroless base image
+// $ dagger call container --bin server --target aarch64-unknown-linux-musl --base gcr.io/distroless/static
+func (r *Rust) Container(
+	ctx context.Context,
+	// the name of the binary to package, as produced by cargo build
+	// +required
+	bin string,
+	// the target triple to build the binary for, the architecture of the resulting image
+	// is derived from it
+	// +optional
+	// +default="x86_64-unknown-linux-musl"
+	target string,
+	// only select the package with the given name within a workspace, through cargo -p
+	// +optional
+	pkg string,
+	// a base image to copy the binary into (e.g. gcr.io/distroless/static), defaults to an
+	// empty (scratch) image
+	// +optional
+	base string,
+) (*dagger.Container, error) {
+	platform, err := targetPlatform(target)
+	if err != nil {
+		return nil, err
+	}
+
+	cross, err := r.withCrossLinker(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	artifacts, err := cross.Build(ctx, true, target, pkg, false)
+	if err != nil {
+		return nil, err
+	}
+
+	binary := artifacts.File(path.Join(target, "release", bin))
+	if _, err := binary.Sync(ctx); err != nil {
+		return nil, fmt.Errorf("binary %s was not built for target %s: %w", bin, target, err)
+	}
+
+	ctr := dag.Container(dagger.ContainerOpts{Platform: platform})
+	if base != "" {
+		ctr = ctr.From(base)
+	}
+
+	binPath := path.Join("/usr/local/bin", bin)
+	return ctr.
+		WithFile(binPath, binary, dagger.ContainerWithFileOpts{Permissions: 0o755}).
+		WithEntrypoint([]string{binPath}), nil
+}
+
+// Configures rust-lld as the linker for a target with a different architecture to the host,
+// as the default linker (cc) of the host cannot link binaries for other architectures
+func (r *Rust) withCrossLinker(ctx context.Context, target string) (*Rust, error) {
+	out, err := r.Base.WithExec([]string{"rustc", "-vV"}).Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var host string
+	for _, line := range strings.Split(out, "\n") {
+		if h, found := strings.CutPrefix(line, "host:"); found {
+			host = strings.TrimSpace(h)
+		}
+	}
+
+	hostArch, _, _ := strings.Cut(host, "-")
+	targetArch, _, _ := strings.Cut(target, "-")
+	if hostArch == targetArch {
+		return r, nil
+	}
+
+	env := fmt.Sprintf("CARGO_TARGET_%s_LINKER", strings.ToUpper(strings.ReplaceAll(target, "-", "_")))
+
+	cross := *r
+	cross.Base = r.Base.WithEnvVariable(env, "rust-lld")
+	return &cross, nil
+}
+
+func targetPlatform(target string) (dagger.Platform, error) {
+	if !strings.Contains(target, "-linux-") {
+		return "", fmt.Errorf("target %s does not produce a linux binary", target)
+	}
+
+	arch, _, _ := strings.Cut(target, "-")
+	switch arch {
+	case "x86_64":
+		return "linux/amd64", nil
+	case "aarch64":
+		return "linux/arm64", nil
+	case "armv7":
+		return "linux/arm/v7", nil
+	case "i686":
+		return "linux/386", nil
+	}
+
+	return "", fmt.Errorf("unable to determine the image platform for target %s", target)
+}
+
+// Execute tests defined within your Rust project. Targets are installed on demand through
+// rustup. Tests for a WASI target (wasm32-wasip1 or wasm32-wasi) are executed using the
+// wasmtime runtime, which is downloaded if missing. Tests cannot be executed for the
+// wasm32-unknown-unknown target, as it has no runtime
+func (r *Rust) Test(
+	ctx context.Context,
+	// run tests in release mode, with optimizations
+	// +optional
+	release bool,
+	// the target triple to test against (e.g. wasm32-wasip1), defaults to the host
+	// +optional
+	target string,
+	// only select the package with the given name within a workspace, through cargo -p
+	// +optional
+	pkg string,
+	// select all packages within a workspace, through cargo --workspace
+	// +optional
+	workspace bool,
+) (string, error) {
+	selection, err := packageSelection(pkg, workspace)
+	if err != nil {
+		return "", err
+	}
+
+	if target == wasmUnknownTarget {
+		return "", fmt.Errorf("tests cannot be executed for the %s target as it has no runtime", target)
+	}
+
+	ctr, err := withTarget(ctx, r.Base, target)
+	if err != nil {
+		return "", err
+	}
+
+	if isWasiTarget(target) {
+		if _, err := ctr.WithExec([]string{"wasmtime", "--version"}).Sync(ctx); err != nil {
+			if ctr, err = withWasmtime(ctx, ctr); err != nil {
+				return "", err
+			}
+		}
+
+		runner := fmt.Sprintf("CARGO_TARGET_%s_RUNNER", strings.ToUpper(strings.ReplaceAll(target, "-", "_")))
+		ctr = ctr.WithEnvVariable(runner, "wasmtime")
+	}
+
+	cmd := append([]string{"cargo", "test"}, selection...)
+	if release {
+		cmd = append(cmd, "--release")
+	}
+
+	if target != "" {
+		cmd = append(cmd, "--target", target)
+	}
+
+	return ctr.WithExec(cmd).Stdout(ctx)
+}
+
+// Execute benchmarks defined within your Rust project using cargo bench. Benchmarks written
+// with criterion are supported, and can optionally report results in a format compatible
+// with the built-in benchmark harness, making them easier to track over time
+func (r *Rust) Bench(
+	ctx context.Context,
+	// report criterion benchmark results in the bencher format. Only supported if all
+	// benchmarks are written using criterion
+	// +optional
+	criterion bool,
+	// only run benchmarks whose names contain this filter
+	// +optional
+	filter string,
+) (string, error) {
+	cmd := []string{"cargo", "bench"}
+	if filter != "" {
+		cmd = append(cmd, filter)
+	}
+
+	if criterion {
+		cmd = append(cmd, "--", "--output-format", "bencher")
+	}
+
+	return r.Base.WithExec(cmd).Stdout(ctx)
+}
+
+// Execute tests defined within your Rust project using miri, an interpreter that detects
+// undefined behavior, such as out-of-bounds memory access and data races, within unsafe code.
+// Miri is only available on the nightly toolchain, which is installed through rustup alongside
+// the stable toolchain of the base image. The stable toolchain remains the default for all
+// other functions
+//
+// Examples:
+//
+// # Run all tests with miri, disabling isolation from the host
+// $ dagger call --src . miri --flags="-Zmiri-disable-isolation"
+func (r *Rust) Miri(
+	ctx context.Context,
+	// only run tests whose names contain this filter
+	// +optional
+	filter string,
+	// a list of flags for configuring miri, exposed through the MIRIFLAGS environment variable
+	// +optional
+	flags []string,
+	// the nightly toolchain to install miri from, can be pinned to a specific date
+	// (e.g. nightly-2024-10-01) to avoid breakages
+	// +optional
+	// +default="nightly"
+	toolchain string,
+) (string, error) {
+	if !strings.HasPrefix(toolchain, "nightly") {
+		return "", fmt.Errorf("miri requires a nightly toolchain, but '%s' was provided", toolchain)
+	}
+
+	ctr := r.Base.
+		WithExec([]string{"rustup", "toolchain", "install", toolchain, "--profile", "minimal", "--component", "miri"}).
+		WithExec([]string{"cargo", "+" + toolchain, "miri", "setup"})
+
+	if len(flags) > 0 {
+		ctr = ctr.WithEnvVariable("MIRIFLAGS", strings.Join(flags, " "))
+	}
+
+	cmd := []string{"cargo", "+" + toolchain, "miri", "test"}
+	if filter != "" {
+		cmd = append(cmd, filter)
+	}
+
+	return ctr.WithExec(cmd).Stdout(ctx)
+}
+
+// Verify your Rust project compiles against its minimum supported Rust version (MSRV),
+// as declared by the rust-version field within its Cargo.toml. The MSRV toolchain is
+// installed through rustup alongside the stable toolchain of the base image, and the
+// project is checked with cargo check. Useful for detecting a dependency that silently
+// raises the MSRV of your project
+//
+// Examples:
+//
+// # Check the project against its declared MSRV
+// $ dagger call --src . msrv
+//
+// # Check a package within a workspace against an explicit MSRV
+// $ dagger call --src . msrv --pkg core --version 1.70
+func (r *Rust) Msrv(
+	ctx context.Context,
+	// only select the package with the given name within a workspace, through cargo -p
+	// +optional
+	pkg string,
+	// override the MSRV declared within the Cargo.toml (e.g. 1.70)
+	// +optional
+	version string,
+) (string, error) {
+	if version == "" {
+		var err error
+		if version, err = declaredMsrv(ctx, r.Base, pkg); err != nil {
+			return "", err
+		}
+	}
+
+	ctr := r.Base.
+		WithExec([]string{"rustup", "toolchain", "install", version, "--profile", "minimal"})
+
+	cmd := []string{"cargo", "+" + version, "check"}
+	if pkg != "" {
+		cmd = append(cmd, "-p", pkg)
+	}
+
+	return ctr.WithExec(cmd).Stderr(ctx)
+}
+
+type cargoMetadata struct {
+	Packages []struct {
+		Name        string `json:"name"`
+		RustVersion string `json:"rust_version"`
+	} `json:"packages"`
+}
+
+// Reads the MSRV declared through the rust-version field of a Cargo.toml, using cargo
+// metadata. Without a package, all packages within a workspace must declare the same MSRV
+func declaredMsrv(ctx context.Context, ctr *dagger.Container, pkg string) (string, error) {
+	out, err := ctr.WithExec([]string{"cargo", "metadata", "--no-deps", "--format-version", "1"}).Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var metadata cargoMetadata
+	if err := json.Unmarshal([]byte(out), &metadata); err != nil {
+		return "", fmt.Errorf("failed to parse cargo metadata: %w", err)
+	}
+
+	var version string
+	for _, p := range metadata.Packages {
+		if pkg != "" && p.Name != pkg {
+			continue
+		}
+
+		if p.RustVersion == "" {
+			return "", fmt.Errorf("package '%s' does not declare a rust-version within its Cargo.toml", p.Name)
+		}
+
+		if version != "" && version != p.RustVersion {
+			return "", fmt.Errorf("packages declare different rust-versions (%s and %s), select a package or provide an explicit version", version, p.RustVersion)
+		}
+		version = p.RustVersion
+	}
+
+	if version == "" {
+		if pkg == "" {
+			return "", fmt.Errorf("no packages exist within the project")
+		}
+		return "", fmt.Errorf("no package named '%s' exists within the project", pkg)
+	}
+
+	return version, nil
+}
+
+// Generates the cargo flags for selecting packages within a workspace
+func packageSelection(pkg string, workspace bool) ([]string, error) {
+	if pkg != "" && workspace {
+		return nil, fmt.Errorf("a package cannot be selected when selecting the entire workspace")
+	}
+
+	if pkg != "" {
+		return []string{"-p", pkg}, nil
+	}
+
+	if workspace {
+		return []string{"--workspace"}, nil
+	}
+
+	return nil, nil
+}
+
+func withTarget(ctx context.Context, ctr *dagger.Container, target string) (*dagger.Container, error) {
+	if target == "" {
+		return ctr, nil
+	}
+
+	installed, err := ctr.WithExec([]string{"rustup", "target", "list", "--installed"}).Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range strings.Fields(installed) {
+		if t == target {
+			return ctr, nil
+		}
+	}
+
+	return ctr.WithExec([]string{"rustup", "target", "add", target}), nil
+}
+
+func isWasiTarget(target string) bool {
+	return target == "wasm32-wasi" || strings.HasPrefix(target, "wasm32-wasip")
+}
+
+// Installs a pinned release of the wasmtime runtime, matching the architecture of the
+// container. The statically linked musl release is preferred, as it runs on any base image
+func withWasmtime(ctx context.Context, ctr *dagger.Container) (*dagger.Container, error) {
+	arch, err := ctr.WithExec([]string{"uname", "-m"}).Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var release string
+	switch arch = strings.TrimSpace(arch); arch {
+	case "x86_64", "aarch64":
+		release = fmt.Sprintf("wasmtime-%s-%s-musl", wasmtimeVersion, arch)
+	default:
+		return nil, fmt.Errorf("wasmtime is not supported on architecture %s", arch)
+	}
+
+	url := fmt.Sprintf("https://github.com/bytecodealliance/wasmtime/releases/download/%s/%s.tar.xz",
+		wasmtimeVersion, release)
+
+	bin := dag.Container().
+		From(alpineImage).
+		WithFile("/tmp/wasmtime.tar.xz", dag.HTTP(url)).
+		WithExec([]string{"tar", "-xJf", "/tmp/wasmtime.tar.xz", "-C", "/tmp"}).
+		File(path.Join("/tmp", release, "wasmtime"))
+
+	return ctr.WithFile(wasmtimeBin, bin, dagger.ContainerWithFileOpts{Permissions: 0o755}), nil
+}
@@ -10,7 +10,11 @@ package main
 import (
 	"context"
 	"dagger/shellcheck/internal/dagger"
+	"encoding/json"
 	"fmt"
+	"path"
+	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -18,6 +22,23 @@ const (
 	ShellcheckGithubRepo = "koalaman/shellcheck"
 	ShellcheckBaseImage  = "koalaman/shellcheck-alpine"
 	WorkingDir           = "/work"
+	EmbeddedDir          = "/embedded"
+)
+
+// Supported strategies for extracting shell embedded within other files
+const (
+	Dockerfile    = "dockerfile"
+	GithubActions = "github-actions"
+	GitlabCI      = "gitlab-ci"
+)
+
+var (
+	// A GitHub Actions expression, e.g. ${{ github.sha }}
+	githubExpr = regexp.MustCompile(`\$\{\{.*?\}\}`)
+	// A YAML block scalar indicator, e.g. | or >-
+	blockScalar = regexp.MustCompile(`^[|>][-+0-9]*\s*(#.*)?$`)
+	// A YAML sequence item, e.g. - echo hello
+	sequenceItem = regexp.MustCompile(`^(\s*-\s+)(.*)$`)
 )
 
 // ShellCheck dagger module
@@ -85,6 +106,435 @@ func (m *Shellcheck) Check(
 	// +required
 	src *dagger.Directory,
 ) (string, error) {
+	cmd := shellcheckCmd(exclude, format, include, paths, severity, shell)
+
+	return m.Base.
+		WithDirectory(WorkingDir, src).
+		WithWorkdir(WorkingDir).
+		WithExec([]string{"sh", "-c", strings.Join(cmd, " ")}).
+		Stdout(ctx)
+}
+
+// A single issue identified by shellcheck within a script
+type ShellcheckFinding struct {
+	// the path of the script containing the issue
+	File string `json:"file"`
+	// the line where the issue starts
+	Line int `json:"line"`
+	// the column where the issue starts
+	Column int `json:"column"`
+	// the severity of the issue (error, warning, info, style)
+	Level string `json:"level"`
+	// the shellcheck code of the issue, e.g. 2086 for SC2086
+	Code int `json:"code"`
+	// a description of the issue
+	Message string `json:"message"`
+}
+
+// Checks shell scripts for syntactic and semantic issues, returning each identified issue
+// as a typed finding. Unlike check, the presence of findings will not result in an error
+func (m *Shellcheck) Report(
+	ctx context.Context,
+	// exclude checks with the following codes
+	// +optional
+	exclude []string,
+	// only consider checks with the following codes
+	// +optional
+	include []string,
+	// a list of paths for checking
+	// +optional
+	// +default=["*.sh"]
+	paths []string,
+	// the minimum severity of errors to consider when checking scripts
+	// (error, warning, info, style)
+	// +optional
+	severity string,
+	// the type of shell dialect to check against (sh, bash, dash, ksh, busybox)
+	// +optional
+	shell string,
+	// a path to a directory containing scripts to scan, this can be a project root
+	// +required
+	src *dagger.Directory,
+) ([]ShellcheckFinding, error) {
+	cmd := shellcheckCmd(exclude, "json", include, paths, severity, shell)
+
+	ctr, err := m.Base.
+		WithDirectory(WorkingDir, src).
+		WithWorkdir(WorkingDir).
+		WithExec([]string{"sh", "-c", strings.Join(cmd, " ")}, dagger.ContainerWithExecOpts{
+			Expect: dagger.ReturnTypeAny,
+		}).
+		Sync(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// shellcheck exits with 1 when issues are found, anything higher is a failure
+	exit, err := ctr.ExitCode(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if exit > 1 {
+		stderr, _ := ctr.Stderr(ctx)
+		return nil, fmt.Errorf("shellcheck failed with exit code %d: %s", exit, stderr)
+	}
+
+	out, err := ctr.Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	findings := []ShellcheckFinding{}
+	if strings.TrimSpace(out) == "" {
+		return findings, nil
+	}
+
+	if err := json.Unmarshal([]byte(out), &findings); err != nil {
+		return nil, fmt.Errorf("failed to parse shellcheck report: %w", err)
+	}
+
+	return findings, nil
+}
+
+// Checks shell embedded within other files for syntactic and semantic issues, such as the
+// RUN instructions of a Dockerfile or the steps of a CI pipeline. Each embedded script is
+// extracted into its own file, named after the file and line it was extracted from, with
+// its original line numbers preserved. The following extraction strategies are supported:
+//
+// - dockerfile: the shell form of every RUN instruction, including heredocs
+// - github-actions: every run step within a GitHub Actions workflow
+// - gitlab-ci: every script, before_script and after_script within a GitLab CI pipeline
+//
+// Examples:
+//
+// # Check the run steps of all GitHub Actions workflows
+// $ dagger call check-embedded --src . --paths ".github/workflows/*.yml" --strategy github-actions
+//
+// # Check the RUN instructions of a Dockerfile
+// $ dagger call check-embedded --src . --paths Dockerfile --strategy dockerfile
+func (m *Shellcheck) CheckEmbedded(
+	ctx context.Context,
+	// exclude checks with the following codes
+	// +optional
+	exclude []string,
+	// the output format of the shellcheck report
+	// (checkstyle, diff, gcc, json, json1, quiet, tty)
+	// +optional
+	format string,
+	// only consider checks with the following codes
+	// +optional
+	include []string,
+	// a list of glob patterns matching the files containing embedded shell
+	// +required
+	paths []string,
+	// the minimum severity of errors to consider when checking scripts
+	// (error, warning, info, style)
+	// +optional
+	severity string,
+	// the type of shell dialect to check against (sh, bash, dash, ksh, busybox). Defaults
+	// to sh for a Dockerfile, and bash for a CI pipeline
+	// +optional
+	shell string,
+	// a path to a directory containing the files to scan, this can be a project root
+	// +required
+	src *dagger.Directory,
+	// the strategy for extracting embedded shell (dockerfile, github-actions, gitlab-ci)
+	// +required
+	strategy string,
+) (string, error) {
+	var extract func(string) map[int][]string
+	switch strategy {
+	case Dockerfile:
+		extract = extractDockerfile
+		if shell == "" {
+			shell = "sh"
+		}
+	case GithubActions:
+		extract = func(contents string) map[int][]string {
+			return extractYaml(contents, []string{"run"})
+		}
+	case GitlabCI:
+		extract = func(contents string) map[int][]string {
+			return extractYaml(contents, []string{"script", "before_script", "after_script"})
+		}
+	default:
+		return "", fmt.Errorf("unsupported strategy '%s', expected one of (%s,%s,%s)", strategy, Dockerfile, GithubActions, GitlabCI)
+	}
+
+	if shell == "" {
+		shell = "bash"
+	}
+
+	var files []string
+	for _, pattern := range paths {
+		matches, err := src.Glob(ctx, pattern)
+		if err != nil {
+			return "", err
+		}
+		files = append(files, matches...)
+	}
+
+	scripts := dag.Directory()
+	var scriptPaths []string
+	for _, file := range files {
+		contents, err := src.File(file).Contents(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		extracted := extract(contents)
+
+		// Sort scripts by line, ensuring the generated report is consistent between runs
+		starts := make([]int, 0, len(extracted))
+		for start := range extracted {
+			starts = append(starts, start)
+		}
+		sort.Ints(starts)
+
+		for _, start := range starts {
+			lines := extracted[start]
+			// Pad the script with empty lines, ensuring any reported line numbers match the original file
+			script := strings.Repeat("\n", start-1) + strings.Join(lines, "\n") + "\n"
+			name := path.Join(sanitizePath(file), fmt.Sprintf("%d.sh", start))
+
+			scripts = scripts.WithNewFile(name, script)
+			scriptPaths = append(scriptPaths, name)
+		}
+	}
+
+	if len(scriptPaths) == 0 {
+		return "", nil
+	}
+
+	cmd := shellcheckCmd(exclude, format, include, scriptPaths, severity, shell)
+
+	return m.Base.
+		WithDirectory(EmbeddedDir, scripts).
+		WithWorkdir(EmbeddedDir).
+		WithExec([]string{"sh", "-c", strings.Join(cmd, " ")}).
+		Stdout(ctx)
+}
+
+// Extracts the shell form of every RUN instruction within a Dockerfile, keyed by the line
+// each script starts on. The RUN keyword and any flags are blanked out, preserving columns
+func extractDockerfile(contents string) map[int][]string {
+	scripts := map[int][]string{}
+	lines := strings.Split(contents, "\n")
+
+	for i := 0; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if len(trimmed) < 4 || !strings.EqualFold(trimmed[:4], "RUN ") {
+			continue
+		}
+
+		// Blank out the RUN keyword and any flags, such as --mount=type=cache,target=/root/.cache.
+		// Flags can be split across multiple lines through a line continuation
+		body := stripFlags(trimmed[4:])
+		for (body == "" || body == "\\") && isContinued(lines[i]) && i+1 < len(lines) {
+			i++
+			if strings.HasPrefix(strings.TrimSpace(lines[i]), "#") {
+				continue
+			}
+			body = stripFlags(strings.TrimRight(lines[i], " \t"))
+		}
+
+		if body == "\\" {
+			continue
+		}
+
+		// The exec form is not executed by a shell
+		if body == "" || strings.HasPrefix(body, "[") {
+			continue
+		}
+		first := strings.Repeat(" ", len(lines[i])-len(body)) + body
+
+		if strings.HasPrefix(body, "<<") {
+			delim := strings.Trim(strings.TrimLeft(strings.Fields(body)[0], "<-"), `"'`)
+
+			var heredoc []string
+			j := i + 1
+			for ; j < len(lines) && strings.TrimSpace(lines[j]) != delim; j++ {
+				heredoc = append(heredoc, lines[j])
+			}
+
+			if len(heredoc) > 0 {
+				scripts[i+2] = heredoc
+			}
+			i = j
+			continue
+		}
+
+		script := []string{first}
+		continued := isContinued(lines[i])
+		for continued && i+1 < len(lines) {
+			i++
+			// Comments within a continuation are removed by docker before execution
+			if strings.HasPrefix(strings.TrimSpace(lines[i]), "#") {
+				script = append(script, "")
+				continue
+			}
+			script = append(script, lines[i])
+			continued = isContinued(lines[i])
+		}
+
+		scripts[i-len(script)+2] = script
+	}
+
+	return scripts
+}
+
+// Strips any leading flags from a RUN instruction, returning the remainder
+func stripFlags(body string) string {
+	for {
+		rest := strings.TrimLeft(body, " \t")
+		if !strings.HasPrefix(rest, "--") {
+			return rest
+		}
+
+		end := strings.IndexAny(rest, " \t")
+		if end == -1 {
+			return ""
+		}
+		body = rest[end:]
+	}
+}
+
+func isContinued(line string) bool {
+	return strings.HasSuffix(strings.TrimRight(line, " \t"), "\\")
+}
+
+// Extracts every script assigned to one of the provided keys within a YAML file, keyed by the
+// line each script starts on. A script can be an inline value, a block scalar or a sequence
+// of either. Everything other than the script is blanked out, preserving columns
+func extractYaml(contents string, keys []string) map[int][]string {
+	keyLine := regexp.MustCompile(`^(\s*(?:-\s+)?)(` + strings.Join(keys, "|") + `):(\s*)(.*)$`)
+
+	scripts := map[int][]string{}
+	lines := strings.Split(contents, "\n")
+	for i := 0; i < len(lines); i++ {
+		match := keyLine.FindStringSubmatch(lines[i])
+		if match == nil {
+			continue
+		}
+
+		keyIndent := len(match[1])
+		value := match[4]
+		start := i + 1
+		valueCol := len(lines[i]) - len(value)
+
+		var script []string
+		switch {
+		case blockScalar.MatchString(value):
+			var block []string
+			block, i = yamlBlock(lines, i+1, keyIndent)
+			start = i - len(block) + 2
+			script = block
+		case value == "" || strings.HasPrefix(value, "#"):
+			start, script, i = yamlSequence(lines, i+1, keyIndent)
+		default:
+			script = []string{strings.Repeat(" ", valueCol) + unquote(value)}
+		}
+
+		if len(script) > 0 {
+			for j := range script {
+				script[j] = githubExpr.ReplaceAllStringFunc(script[j], func(expr string) string {
+					return strings.Repeat("x", len(expr))
+				})
+			}
+			scripts[start] = script
+		}
+	}
+
+	return scripts
+}
+
+// Collects the lines of a block scalar, which are indented beyond its parent. Returns the
+// block and the index of its last line
+func yamlBlock(lines []string, from, parentIndent int) ([]string, int) {
+	var block []string
+	last := from - 1
+	for j := from; j < len(lines); j++ {
+		if strings.TrimSpace(lines[j]) == "" {
+			block = append(block, "")
+			continue
+		}
+
+		if indentOf(lines[j]) <= parentIndent {
+			break
+		}
+
+		block = append(block, lines[j])
+		last = j
+	}
+
+	// Trailing empty lines belong to whatever follows the block
+	return block[:last-from+1], last
+}
+
+// Collects the items of a sequence into a single script, as each item is executed within the
+// same shell. Returns the line the script starts on, the script and the index of its last line
+func yamlSequence(lines []string, from, keyIndent int) (int, []string, int) {
+	var script []string
+	start := from + 1
+	last := from - 1
+	for j := from; j < len(lines); j++ {
+		if strings.TrimSpace(lines[j]) == "" {
+			continue
+		}
+
+		item := sequenceItem.FindStringSubmatch(lines[j])
+		if item == nil || indentOf(lines[j]) < keyIndent {
+			break
+		}
+
+		// Pad any gaps between items, ensuring line numbers are preserved
+		if len(script) == 0 {
+			start = j + 1
+		}
+		for len(script) < j-start+1 {
+			script = append(script, "")
+		}
+
+		if blockScalar.MatchString(item[2]) {
+			block, end := yamlBlock(lines, j+1, indentOf(lines[j]))
+			script = append(script, "")
+			script = append(script, block...)
+			j = end
+		} else {
+			script = append(script, strings.Repeat(" ", len(item[1]))+unquote(item[2]))
+		}
+		last = j
+	}
+
+	return start, script, last
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// Blanks out the quotes surrounding a YAML scalar, preserving columns
+func unquote(value string) string {
+	value = strings.TrimRight(value, " ")
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return " " + value[1:len(value)-1]
+	}
+	return value
+}
+
+// Replaces any characters within a path that could be misinterpreted by the shell
+func sanitizePath(p string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', strings.ContainsRune("._/-", r):
+			return r
+		}
+		return '_'
+	}, p)
+}
+
+func shellcheckCmd(exclude []string, format string, include []string, paths []string, severity, shell string) []string {
 	cmd := []string{"shellcheck"}
 	if len(exclude) > 0 {
 		cmd = append(cmd, "--exclude", strings.Join(exclude, ","))
@@ -106,13 +556,5 @@ func (m *Shellcheck) Check(
 		cmd = append(cmd, "--shell", shell)
 	}
 
-	for _, toCheck := range paths {
-		cmd = append(cmd, toCheck)
-	}
-
-	return m.Base.
-		WithDirectory(WorkingDir, src).
-		WithWorkdir(WorkingDir).
-		WithExec([]string{"sh", "-c", strings.Join(cmd, " ")}).
-		Stdout(ctx)
+	return append(cmd, paths...)
 }
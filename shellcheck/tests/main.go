@@ -40,6 +40,11 @@ func (m *Tests) AllTests(ctx context.Context) error {
 	p.Go(m.CheckInvalidFile)
 	p.Go(m.CheckInvalidFileWithInclude)
 	p.Go(m.CheckInvalidFileWithExclude)
+	p.Go(m.ReportValidFile)
+	p.Go(m.ReportInvalidFile)
+	p.Go(m.CheckEmbeddedDockerfile)
+	p.Go(m.CheckEmbeddedGithubActions)
+	p.Go(m.CheckEmbeddedGitlabCI)
 
 	return p.Wait()
 }
@@ -157,3 +162,164 @@ func (m *Tests) CheckInvalidFileWithExclude(ctx context.Context) error {
 
 	return nil
 }
+
+func (m *Tests) ReportValidFile(ctx context.Context) error {
+	dir := dag.Directory().
+		WithNewFile("valid.sh", validScript, dagger.DirectoryWithNewFileOpts{Permissions: 0o755})
+
+	findings, err := dag.Shellcheck().Report(ctx, dir, dagger.ShellcheckReportOpts{Paths: []string{"valid.sh"}})
+	if err != nil {
+		return err
+	}
+
+	if len(findings) != 0 {
+		return fmt.Errorf("shellcheck report should have no findings but has %d", len(findings))
+	}
+
+	return nil
+}
+
+func (m *Tests) ReportInvalidFile(ctx context.Context) error {
+	dir := dag.Directory().
+		WithNewFile("invalid.sh", invalidScript, dagger.DirectoryWithNewFileOpts{Permissions: 0o755})
+
+	findings, err := dag.Shellcheck().Report(ctx, dir, dagger.ShellcheckReportOpts{Paths: []string{"invalid.sh"}})
+	if err != nil {
+		return err
+	}
+
+	if len(findings) != 2 {
+		return fmt.Errorf("shellcheck report should have 2 findings but has %d", len(findings))
+	}
+
+	for _, finding := range findings {
+		level, err := finding.Level(ctx)
+		if err != nil {
+			return err
+		}
+
+		if level == "error" {
+			return fmt.Errorf("shellcheck report should have no error level findings")
+		}
+	}
+
+	file, err := findings[0].File(ctx)
+	if err != nil {
+		return err
+	}
+
+	if file != "invalid.sh" {
+		return fmt.Errorf("shellcheck finding file does not match:\n%s", diff.LineDiff(file, "invalid.sh"))
+	}
+
+	code, err := findings[0].Code(ctx)
+	if err != nil {
+		return err
+	}
+
+	if code != 3030 {
+		return fmt.Errorf("shellcheck finding should have code 3030 but has %d", code)
+	}
+
+	return nil
+}
+
+func (m *Tests) CheckEmbeddedDockerfile(ctx context.Context) error {
+	dockerfile := `FROM alpine:3.20
+RUN apk add --no-cache curl
+RUN --mount=type=cache,target=/root/.cache \
+    echo $1
+`
+	dir := dag.Directory().WithNewFile("Dockerfile", dockerfile)
+
+	opts := dagger.ShellcheckCheckEmbeddedOpts{Format: "json"}
+
+	_, err := dag.Shellcheck().CheckEmbedded(ctx, []string{"Dockerfile"}, dir, "dockerfile", opts)
+	if err == nil {
+		return fmt.Errorf("shellcheck should have reported issues within the embedded shell")
+	}
+
+	actual := err.Error()
+	if idx := strings.Index(actual, "[{"); idx != -1 {
+		actual = actual[idx:]
+	}
+
+	var checks []ShellcheckReportItem
+	if err := json.NewDecoder(strings.NewReader(actual)).Decode(&checks); err != nil {
+		return err
+	}
+
+	if len(checks) != 1 {
+		return fmt.Errorf("shellcheck report should have 1 item but has %d", len(checks))
+	}
+
+	if checks[0].Line != 4 || checks[0].Code != 2086 {
+		return fmt.Errorf("shellcheck report line does not match:\n%s",
+			diff.LineDiff(checks[0].String(), "4:info:2086:Double quote to prevent globbing and word splitting."))
+	}
+
+	return nil
+}
+
+func (m *Tests) CheckEmbeddedGithubActions(ctx context.Context) error {
+	workflow := `name: ci
+on: push
+jobs:
+  build:
+    runs-on: ubuntu-latest
+    steps:
+      - run: echo "${{ github.ref }}"
+      - name: test
+        run: |
+          echo "start"
+          echo $1
+`
+	dir := dag.Directory().WithNewFile(".github/workflows/ci.yml", workflow)
+
+	return checkEmbeddedLine(ctx, dir, ".github/workflows/*.yml", "github-actions", 11)
+}
+
+func (m *Tests) CheckEmbeddedGitlabCI(ctx context.Context) error {
+	pipeline := `build:
+  before_script: echo "setup"
+  script:
+    - echo "start"
+    - echo $1
+`
+	dir := dag.Directory().WithNewFile(".gitlab-ci.yml", pipeline)
+
+	return checkEmbeddedLine(ctx, dir, ".gitlab-ci.yml", "gitlab-ci", 5)
+}
+
+// Checks the embedded shell within a single file, expecting a single unquoted variable
+// to be reported on the given line of the original file
+func checkEmbeddedLine(ctx context.Context, dir *dagger.Directory, pattern, strategy string, line int) error {
+	opts := dagger.ShellcheckCheckEmbeddedOpts{Format: "json"}
+
+	_, err := dag.Shellcheck().CheckEmbedded(ctx, []string{pattern}, dir, strategy, opts)
+	if err == nil {
+		return fmt.Errorf("shellcheck should have reported issues within the embedded shell")
+	}
+
+	actual := err.Error()
+	if idx := strings.Index(actual, "[{"); idx != -1 {
+		actual = actual[idx:]
+	}
+
+	var checks []ShellcheckReportItem
+	if err := json.NewDecoder(strings.NewReader(actual)).Decode(&checks); err != nil {
+		return err
+	}
+
+	if len(checks) != 1 {
+		return fmt.Errorf("shellcheck report should have 1 item but has %d", len(checks))
+	}
+
+	expected := fmt.Sprintf("%d:info:2086:Double quote to prevent globbing and word splitting.", line)
+	if checks[0].Line != line || checks[0].Code != 2086 {
+		return fmt.Errorf("shellcheck report line does not match:\n%s",
+			diff.LineDiff(checks[0].String(), expected))
+	}
+
+	return nil
+}
@@ -6,8 +6,14 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"dagger/trivy/internal/dagger"
 )
@@ -16,6 +22,14 @@ const (
 	TrivyGithubRepo = "aquasecurity/trivy"
 	TrivyBaseImage  = "ghcr.io/aquasecurity/trivy"
 	TrivyWorkDir    = "scan"
+	TrivyPolicyDir  = "/trivy/policy"
+	TrivyDataDir    = "/trivy/data"
+	TrivyIgnoreRego = "/trivy/ignore.rego"
+	TrivyKubeConfig = "/trivy/kubeconfig"
+	TrivySecretYaml = "/trivy/secret.yaml"
+	TrivyReport     = "/trivy/report.json"
+	TrivyCacheDir   = "/root/.cache/trivy"
+	TrivyJavaDbDir  = "/root/.cache/trivy/java-db"
 )
 
 // Trivy Dagger Module
@@ -28,21 +42,42 @@ type Trivy struct {
 	// will be loaded automatically
 	// +private
 	IgnoreFile string
+	// An OCI repository to download the Java index DB from, in place of the default
+	// +private
+	JavaDbRepository string
+	// Identifies whether updating the Java index DB should be skipped
+	// +private
+	SkipJavaDbUpdate bool
 }
 
 type scanArgs struct {
+	Compliance    string
 	ExitCode      int
 	Format        string
 	IgnoreFile    string
+	IgnorePolicy  string
 	IgnoreUnfixed bool
+	JavaDbRepo    string
+	Report        string
 	Scanners      string
+	SecretConfig  string
 	Severity      string
+	SkipJavaDb    bool
 	Template      string
+	Timeout       string
 	VulnType      string
 }
 
 func (a scanArgs) args() []string {
 	args := []string{}
+	if a.Compliance != "" {
+		args = append(args, "--compliance", a.Compliance)
+
+		if a.Report != "" {
+			args = append(args, "--report", a.Report)
+		}
+	}
+
 	if a.ExitCode != 0 {
 		args = append(args, "--exit-code", strconv.Itoa(a.ExitCode))
 	}
@@ -55,22 +90,42 @@ func (a scanArgs) args() []string {
 		args = append(args, "--ignorefile", a.IgnoreFile)
 	}
 
+	if a.IgnorePolicy != "" {
+		args = append(args, "--ignore-policy", a.IgnorePolicy)
+	}
+
 	if a.IgnoreUnfixed {
 		args = append(args, "--ignore-unfixed")
 	}
 
+	if a.JavaDbRepo != "" {
+		args = append(args, "--java-db-repository", a.JavaDbRepo)
+	}
+
 	if a.Scanners != "" {
 		args = append(args, "--scanners", a.Scanners)
 	}
 
+	if a.SecretConfig != "" {
+		args = append(args, "--secret-config", a.SecretConfig)
+	}
+
 	if a.Severity != "" {
 		args = append(args, "--severity", a.Severity)
 	}
 
+	if a.SkipJavaDb {
+		args = append(args, "--skip-java-db-update")
+	}
+
 	if a.Template != "" {
 		args = append(args, "--template", a.Template)
 	}
 
+	if a.Timeout != "" {
+		args = append(args, "--timeout", a.Timeout)
+	}
+
 	if a.VulnType != "" {
 		args = append(args, "--vuln-type", a.VulnType)
 	}
@@ -78,6 +133,140 @@ func (a scanArgs) args() []string {
 	return args
 }
 
+func validateCompliance(compliance, report string, maxFindings int) error {
+	if compliance == "" {
+		return nil
+	}
+
+	if maxFindings > 0 {
+		return fmt.Errorf("a maximum number of findings cannot be used when generating a compliance report")
+	}
+
+	switch report {
+	case "summary", "all":
+		return nil
+	default:
+		return fmt.Errorf("unsupported report '%s', expected one of (summary,all)", report)
+	}
+}
+
+func validateTimeout(timeout string) error {
+	if timeout == "" {
+		return nil
+	}
+
+	if _, err := time.ParseDuration(timeout); err != nil {
+		return fmt.Errorf("invalid timeout '%s', expected a duration such as 10m or 1h30m: %w", timeout, err)
+	}
+	return nil
+}
+
+// Mounts custom policies for detecting misconfigurations. Rego policies are mounted
+// as config policies, while any other file is treated as data used by those policies
+func withPolicies(ctx context.Context, ctr *dagger.Container, policies []*dagger.File) (*dagger.Container, []string, error) {
+	var hasPolicy, hasData bool
+	for _, policy := range policies {
+		name, err := policy.Name(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if filepath.Ext(name) == ".rego" {
+			ctr = ctr.WithMountedFile(filepath.Join(TrivyPolicyDir, name), policy)
+			hasPolicy = true
+		} else {
+			ctr = ctr.WithMountedFile(filepath.Join(TrivyDataDir, name), policy)
+			hasData = true
+		}
+	}
+
+	var args []string
+	if hasPolicy {
+		args = append(args, "--config-policy", TrivyPolicyDir)
+	}
+
+	if hasData {
+		args = append(args, "--config-data", TrivyDataDir)
+	}
+
+	return ctr, args, nil
+}
+
+// Enables the misconfig scanner alongside the selected scanners, defaulting to those
+// enabled by trivy when scanning a filesystem (vuln,secret)
+func withMisconfigScanner(scanners string) string {
+	if scanners == "" {
+		return "vuln,secret,misconfig"
+	}
+
+	if slices.Contains(strings.Split(scanners, ","), "misconfig") {
+		return scanners
+	}
+	return scanners + ",misconfig"
+}
+
+// Executes a trivy scan. If a maximum number of findings is provided, a JSON report is
+// generated to ensure the number of findings are within budget. The JSON report is then
+// converted into the requested format, avoiding the need to scan a second time
+func scan(ctx context.Context, ctr *dagger.Container, cmd []string, sargs scanArgs, maxFindings int) (string, error) {
+	if maxFindings <= 0 {
+		return ctr.WithExec(append(cmd, sargs.args()...)).Stdout(ctx)
+	}
+
+	budget := sargs
+	budget.ExitCode = 0
+	budget.Format = "json"
+	budget.Template = ""
+
+	scanned := ctr.WithExec(append(append(cmd, budget.args()...), "--output", TrivyReport))
+	out, err := scanned.File(TrivyReport).Contents(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var rep report
+	if err := json.Unmarshal([]byte(out), &rep); err != nil {
+		return "", fmt.Errorf("failed to parse report: %w", err)
+	}
+
+	if count := rep.findings(); count > maxFindings {
+		return "", fmt.Errorf("found %d findings, exceeding the maximum of %d allowed", count, maxFindings)
+	}
+
+	if sargs.Format == "json" {
+		return out, nil
+	}
+
+	convert := []string{"convert"}
+	if sargs.Format != "" {
+		convert = append(convert, "--format", sargs.Format)
+	}
+
+	if sargs.Template != "" {
+		convert = append(convert, "--template", sargs.Template)
+	}
+
+	return scanned.WithExec(append(convert, TrivyReport)).Stdout(ctx)
+}
+
+// Mounts a Rego policy for programmatically suppressing findings during a scan
+func withIgnorePolicy(ctr *dagger.Container, policy *dagger.File) (*dagger.Container, string) {
+	if policy == nil {
+		return ctr, ""
+	}
+
+	return ctr.WithMountedFile(TrivyIgnoreRego, policy), TrivyIgnoreRego
+}
+
+// Mounts a custom ruleset for tuning the detection of secrets during a scan
+func withSecretConfig(ctr *dagger.Container, cfg *dagger.File) (*dagger.Container, string) {
+	if cfg == nil {
+		return ctr, ""
+	}
+
+	return ctr.WithMountedFile(TrivySecretYaml, cfg), TrivySecretYaml
+}
+
 // New initializes the trivy dagger module
 func New(
 	ctx context.Context,
@@ -103,7 +292,9 @@ func New(
 		}
 	}
 
-	base = base.WithMountedCache("/root/.cache/trivy", dag.CacheVolume("trivydb")).
+	// The Java index DB is large, so is cached separately from the vulnerability DB
+	base = base.WithMountedCache(TrivyCacheDir, dag.CacheVolume("trivydb")).
+		WithMountedCache(TrivyJavaDbDir, dag.CacheVolume("trivy-javadb")).
 		WithWorkdir(TrivyWorkDir)
 
 	if cfg != nil {
@@ -129,6 +320,32 @@ func New(
 	return &Trivy{Base: base, IgnoreFile: ignoreFilePath}, err
 }
 
+// Configures how the Java index DB is retrieved when scanning Java applications, such as
+// a JAR file. The Java index DB is cached separately from the vulnerability DB, avoiding
+// repeated downloads
+//
+// Examples:
+//
+// # Scan an image using a mirrored Java index DB
+// $ trivy with-java-db --repository registry:5000/trivy-java-db:1 image --ref app:latest
+//
+// # Scan a filesystem using a previously cached Java index DB
+// $ trivy with-java-db --skip-update filesystem --dir .
+func (t *Trivy) WithJavaDb(
+	// an OCI repository to download the Java index DB from, such as a mirror
+	// (e.g. registry:5000/trivy-java-db:1)
+	// +optional
+	repository string,
+	// skip updating the Java index DB, using the cached copy instead. The Java index DB
+	// must have been downloaded by a previous scan
+	// +optional
+	skipUpdate bool,
+) *Trivy {
+	t.JavaDbRepository = repository
+	t.SkipJavaDbUpdate = skipUpdate
+	return t
+}
+
 func defaultImage(ctx context.Context) (*dagger.Container, error) {
 	tag, err := dag.Github().GetLatestRelease("aquasecurity/trivy").Tag(ctx)
 	if err != nil {
@@ -160,15 +377,32 @@ func defaultImage(ctx context.Context) (*dagger.Container, error) {
 // $ trivy --ignore-file .trivyignore image --ref golang:1.21.7-bookworm
 func (t *Trivy) Image(
 	ctx context.Context,
+	// generate a compliance report against a given standard (e.g. docker-cis-1.6.0),
+	// https://aquasecurity.github.io/trivy/latest/docs/compliance/compliance/
+	// +optional
+	compliance string,
+	// the level of detail within a generated compliance report (summary,all)
+	// +optional
+	// +default="all"
+	complianceReport string,
 	// the returned exit code when vulnerabilities are detected (0)
 	// +optional
 	exitCode int,
 	// the type of format to use when generating the compliance report (table)
 	// +optional
 	format string,
+	// a Rego policy for programmatically suppressing findings, offering more control
+	// than an ignore file, https://aquasecurity.github.io/trivy/latest/docs/configuration/filtering/#by-rego
+	// +optional
+	ignorePolicy *dagger.File,
 	// filter out any vulnerabilities without a known fix
 	// +optional
 	ignoreUnfixed bool,
+	// the maximum number of findings tolerated before failing, counting only those matching
+	// the selected severities. Useful for allowing known issues during a remediation window.
+	// Disabled by default
+	// +optional
+	maxFindings int,
 	// the password for authenticating with the registry
 	// +optional
 	password *dagger.Secret,
@@ -182,6 +416,10 @@ func (t *Trivy) Image(
 	// the types of scanner to execute (vuln,secret)
 	// +optional
 	scanners string,
+	// a custom ruleset for detecting secrets, such as organization specific tokens, when
+	// the secret scanner is enabled, https://aquasecurity.github.io/trivy/latest/docs/scanner/secret/#configuration
+	// +optional
+	secretConfig *dagger.File,
 	// the severity of security issues to detect (UNKNOWN,LOW,MEDIUM,HIGH,CRITICAL)
 	// +optional
 	severity string,
@@ -195,26 +433,240 @@ func (t *Trivy) Image(
 	// +optional
 	vulnType string,
 ) (string, error) {
+	if err := validateCompliance(compliance, complianceReport, maxFindings); err != nil {
+		return "", err
+	}
+
 	cmd := []string{"image", ref}
 
+	ctr, ignorePolicyPath := withIgnorePolicy(t.Base, ignorePolicy)
+	ctr, secretConfigPath := withSecretConfig(ctr, secretConfig)
+
 	sargs := scanArgs{
+		Compliance:    compliance,
 		ExitCode:      exitCode,
 		Format:        format,
 		IgnoreFile:    t.IgnoreFile,
+		IgnorePolicy:  ignorePolicyPath,
 		IgnoreUnfixed: ignoreUnfixed,
+		JavaDbRepo:    t.JavaDbRepository,
+		Report:        complianceReport,
 		Scanners:      scanners,
+		SecretConfig:  secretConfigPath,
 		Severity:      severity,
+		SkipJavaDb:    t.SkipJavaDbUpdate,
 		Template:      template,
 		VulnType:      vulnType,
 	}
-	cmd = append(cmd, sargs.args()...)
+
+	if registry != "" && username != "" && password != nil {
+		ctr = ctr.WithRegistryAuth(registry, username, password)
+	}
+
+	return scan(ctx, ctr, cmd, sargs, maxFindings)
+}
+
+// A subset of the JSON report generated by trivy when scanning for vulnerabilities. When
+// scanning a Kubernetes cluster, results are grouped by resource instead
+type report struct {
+	Results   []result `json:"Results"`
+	Resources []struct {
+		Results []result `json:"Results"`
+	} `json:"Resources"`
+}
+
+type result struct {
+	Target            string          `json:"Target"`
+	Vulnerabilities   []vulnerability `json:"Vulnerabilities"`
+	Misconfigurations []struct {
+		ID string `json:"ID"`
+	} `json:"Misconfigurations"`
+	Secrets []struct {
+		RuleID string `json:"RuleID"`
+	} `json:"Secrets"`
+}
+
+func (r report) findings() int {
+	results := r.Results
+	for _, resource := range r.Resources {
+		results = append(results, resource.Results...)
+	}
+
+	var count int
+	for _, result := range results {
+		count += len(result.Vulnerabilities) + len(result.Misconfigurations) + len(result.Secrets)
+	}
+	return count
+}
+
+type vulnerability struct {
+	VulnerabilityID  string `json:"VulnerabilityID"`
+	PkgName          string `json:"PkgName"`
+	InstalledVersion string `json:"InstalledVersion"`
+	FixedVersion     string `json:"FixedVersion"`
+	Severity         string `json:"Severity"`
+	Title            string `json:"Title"`
+}
+
+// A vulnerability aggregated across all images in which it was detected
+type aggregatedVulnerability struct {
+	vulnerability
+	Images []string `json:"Images"`
+}
+
+type imageSummary struct {
+	Image           string `json:"Image"`
+	Vulnerabilities int    `json:"Vulnerabilities"`
+}
+
+type aggregatedReport struct {
+	Summary         []imageSummary            `json:"Summary"`
+	Vulnerabilities []aggregatedVulnerability `json:"Vulnerabilities"`
+}
+
+// Scan multiple published (or remote) images for any vulnerabilities, merging all findings
+// into a single report. Vulnerabilities shared between images, such as those within a common
+// base image, are only reported once, listing every affected image. A summary of the number
+// of vulnerabilities detected within each image is included
+//
+// Examples:
+//
+// # Scan multiple container images
+// $ trivy images --refs golang:1.21.7-bookworm,golang:1.22.0-bookworm
+//
+// # Generate a combined JSON report
+// $ trivy images --format json --refs golang:1.21.7-bookworm,golang:1.22.0-bookworm
+func (t *Trivy) Images(
+	ctx context.Context,
+	// the type of format to use when generating the combined report (table,json)
+	// +optional
+	// +default="table"
+	format string,
+	// filter out any vulnerabilities without a known fix
+	// +optional
+	ignoreUnfixed bool,
+	// the password for authenticating with the registry
+	// +optional
+	password *dagger.Secret,
+	// a list of references to images within a repository
+	// +required
+	refs []string,
+	// the address of the registry to authenticate with
+	// +optional
+	// +default="docker.io"
+	registry string,
+	// the severity of security issues to detect (UNKNOWN,LOW,MEDIUM,HIGH,CRITICAL)
+	// +optional
+	severity string,
+	// the username for authenticating with the registry
+	// +optional
+	username string,
+	// the types of vulnerabilities to scan for (os,library)
+	// +optional
+	vulnType string,
+) (string, error) {
+	if format != "table" && format != "json" {
+		return "", fmt.Errorf("unsupported format '%s', expected one of (table,json)", format)
+	}
+
+	if len(refs) == 0 {
+		return "", fmt.Errorf("at least one image reference must be provided")
+	}
 
 	ctr := t.Base
 	if registry != "" && username != "" && password != nil {
-		ctr = t.Base.WithRegistryAuth(registry, username, password)
+		ctr = ctr.WithRegistryAuth(registry, username, password)
+	}
+
+	sargs := scanArgs{
+		Format:        "json",
+		IgnoreFile:    t.IgnoreFile,
+		IgnoreUnfixed: ignoreUnfixed,
+		JavaDbRepo:    t.JavaDbRepository,
+		Scanners:      "vuln",
+		Severity:      severity,
+		SkipJavaDb:    t.SkipJavaDbUpdate,
+		VulnType:      vulnType,
 	}
 
-	return ctr.WithExec(cmd).Stdout(ctx)
+	var agg aggregatedReport
+	seen := map[string]int{}
+	for _, ref := range refs {
+		cmd := append([]string{"image", ref}, sargs.args()...)
+
+		out, err := ctr.WithExec(cmd).Stdout(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		var rep report
+		if err := json.Unmarshal([]byte(out), &rep); err != nil {
+			return "", fmt.Errorf("failed to parse report for image '%s': %w", ref, err)
+		}
+
+		summary := imageSummary{Image: ref}
+		for _, result := range rep.Results {
+			for _, vuln := range result.Vulnerabilities {
+				summary.Vulnerabilities++
+
+				key := vuln.VulnerabilityID + "/" + vuln.PkgName + "@" + vuln.InstalledVersion
+				if i, found := seen[key]; found {
+					if images := agg.Vulnerabilities[i].Images; images[len(images)-1] != ref {
+						agg.Vulnerabilities[i].Images = append(images, ref)
+					}
+					continue
+				}
+
+				seen[key] = len(agg.Vulnerabilities)
+				agg.Vulnerabilities = append(agg.Vulnerabilities, aggregatedVulnerability{
+					vulnerability: vuln,
+					Images:        []string{ref},
+				})
+			}
+		}
+		agg.Summary = append(agg.Summary, summary)
+	}
+
+	if format == "json" {
+		out, err := json.MarshalIndent(agg, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		return string(out), nil
+	}
+
+	return agg.table(), nil
+}
+
+func (r aggregatedReport) table() string {
+	var buf strings.Builder
+
+	tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "IMAGE\tVULNERABILITIES")
+	for _, summary := range r.Summary {
+		fmt.Fprintf(tw, "%s\t%d\n", summary.Image, summary.Vulnerabilities)
+	}
+	tw.Flush()
+
+	if len(r.Vulnerabilities) == 0 {
+		return buf.String()
+	}
+
+	buf.WriteString("\n")
+	tw = tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VULNERABILITY\tSEVERITY\tPACKAGE\tINSTALLED\tFIXED\tIMAGES")
+	for _, vuln := range r.Vulnerabilities {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			vuln.VulnerabilityID,
+			vuln.Severity,
+			vuln.PkgName,
+			vuln.InstalledVersion,
+			vuln.FixedVersion,
+			strings.Join(vuln.Images, ","))
+	}
+	tw.Flush()
+
+	return buf.String()
 }
 
 // Scan a locally exported image for any vulnerabilities
@@ -239,21 +691,42 @@ func (t *Trivy) Image(
 // $ trivy --ignore-file .trivyignore image-local --ref image.tar
 func (t *Trivy) ImageLocal(
 	ctx context.Context,
+	// generate a compliance report against a given standard (e.g. docker-cis-1.6.0),
+	// https://aquasecurity.github.io/trivy/latest/docs/compliance/compliance/
+	// +optional
+	compliance string,
+	// the level of detail within a generated compliance report (summary,all)
+	// +optional
+	// +default="all"
+	complianceReport string,
 	// the returned exit code when vulnerabilities are detected (0)
 	// +optional
 	exitCode int,
 	// the type of format to use when generating the compliance report (table)
 	// +optional
 	format string,
+	// a Rego policy for programmatically suppressing findings, offering more control
+	// than an ignore file, https://aquasecurity.github.io/trivy/latest/docs/configuration/filtering/#by-rego
+	// +optional
+	ignorePolicy *dagger.File,
 	// filter out any vulnerabilities without a known fix
 	// +optional
 	ignoreUnfixed bool,
+	// the maximum number of findings tolerated before failing, counting only those matching
+	// the selected severities. Useful for allowing known issues during a remediation window.
+	// Disabled by default
+	// +optional
+	maxFindings int,
 	// the path to an exported image tar
 	// +required
 	ref *dagger.File,
 	// the types of scanner to execute (vuln,secret)
 	// +optional
 	scanners string,
+	// a custom ruleset for detecting secrets, such as organization specific tokens, when
+	// the secret scanner is enabled, https://aquasecurity.github.io/trivy/latest/docs/scanner/secret/#configuration
+	// +optional
+	secretConfig *dagger.File,
 	// the severity of security issues to detect (UNKNOWN,LOW,MEDIUM,HIGH,CRITICAL)
 	// +optional
 	severity string,
@@ -264,24 +737,33 @@ func (t *Trivy) ImageLocal(
 	// +optional
 	vulnType string,
 ) (string, error) {
+	if err := validateCompliance(compliance, complianceReport, maxFindings); err != nil {
+		return "", err
+	}
+
 	cmd := []string{"image", "--input", "image.tar"}
 
+	ctr, ignorePolicyPath := withIgnorePolicy(t.Base, ignorePolicy)
+	ctr, secretConfigPath := withSecretConfig(ctr, secretConfig)
+
 	sargs := scanArgs{
+		Compliance:    compliance,
 		ExitCode:      exitCode,
 		Format:        format,
 		IgnoreFile:    t.IgnoreFile,
+		IgnorePolicy:  ignorePolicyPath,
 		IgnoreUnfixed: ignoreUnfixed,
+		JavaDbRepo:    t.JavaDbRepository,
+		Report:        complianceReport,
 		Scanners:      scanners,
+		SecretConfig:  secretConfigPath,
 		Severity:      severity,
+		SkipJavaDb:    t.SkipJavaDbUpdate,
 		Template:      template,
 		VulnType:      vulnType,
 	}
-	cmd = append(cmd, sargs.args()...)
 
-	return t.Base.
-		WithMountedFile("image.tar", ref).
-		WithExec(cmd).
-		Stdout(ctx)
+	return scan(ctx, ctr.WithMountedFile("image.tar", ref), cmd, sargs, maxFindings)
 }
 
 // Scan a filesystem for any vulnerabilities
@@ -307,38 +789,367 @@ func (t *Trivy) Filesystem(
 	// the type of format to use when generating the compliance report (table)
 	// +optional
 	format string,
+	// a Rego policy for programmatically suppressing findings, offering more control
+	// than an ignore file, https://aquasecurity.github.io/trivy/latest/docs/configuration/filtering/#by-rego
+	// +optional
+	ignorePolicy *dagger.File,
 	// filter out any vulnerabilities without a known fix
 	// +optional
 	ignoreUnfixed bool,
-	// the types of scanner to execute (vuln,secret)
+	// the maximum number of findings tolerated before failing, counting only those matching
+	// the selected severities. Useful for allowing known issues during a remediation window.
+	// Disabled by default
+	// +optional
+	maxFindings int,
+	// a list of custom policies for detecting misconfigurations. Rego files (.rego)
+	// are loaded as policies, all other files are loaded as data for those policies.
+	// Policies are only evaluated by the misconfig scanner, which is enabled automatically
+	// +optional
+	policy []*dagger.File,
+	// the types of scanner to execute (vuln,secret,misconfig)
 	// +optional
 	scanners string,
+	// a custom ruleset for detecting secrets, such as organization specific tokens, when
+	// the secret scanner is enabled, https://aquasecurity.github.io/trivy/latest/docs/scanner/secret/#configuration
+	// +optional
+	secretConfig *dagger.File,
 	// the severity of security issues to detect (UNKNOWN,LOW,MEDIUM,HIGH,CRITICAL)
 	// +optional
 	severity string,
 	// a custom go template to use when generating the compliance report
 	// +optional
 	template string,
+	// the maximum duration of the scan (e.g. 20m)
+	// +optional
+	timeout string,
 	// the types of vulnerabilities to scan for (os,library)
 	// +optional
 	vulnType string,
 ) (string, error) {
+	if err := validateTimeout(timeout); err != nil {
+		return "", err
+	}
+
+	if len(policy) > 0 {
+		scanners = withMisconfigScanner(scanners)
+	}
+
 	cmd := []string{"filesystem", "."}
 
+	ctr, ignorePolicyPath := withIgnorePolicy(t.Base, ignorePolicy)
+	ctr, secretConfigPath := withSecretConfig(ctr, secretConfig)
+
 	sargs := scanArgs{
 		ExitCode:      exitCode,
 		Format:        format,
 		IgnoreFile:    t.IgnoreFile,
+		IgnorePolicy:  ignorePolicyPath,
 		IgnoreUnfixed: ignoreUnfixed,
+		JavaDbRepo:    t.JavaDbRepository,
 		Scanners:      scanners,
+		SecretConfig:  secretConfigPath,
 		Severity:      severity,
+		SkipJavaDb:    t.SkipJavaDbUpdate,
 		Template:      template,
+		Timeout:       timeout,
 		VulnType:      vulnType,
 	}
-	cmd = append(cmd, sargs.args()...)
+
+	ctr, policyArgs, err := withPolicies(ctx, ctr, policy)
+	if err != nil {
+		return "", err
+	}
+	cmd = append(cmd, policyArgs...)
+
+	return scan(ctx, ctr.WithDirectory(TrivyWorkDir, dir), cmd, sargs, maxFindings)
+}
+
+// Scan configuration files for any misconfigurations
+//
+// Examples:
+//
+// # Scan a directory of IaC files
+// $ trivy config --dir /path/to/your_project
+//
+// # Filter by severities
+// $ trivy config --severity HIGH,CRITICAL --dir /path/to/your_project
+//
+// # Scan using custom policies
+// $ trivy config --policy policy.rego --policy data.yaml --dir /path/to/your_project
+func (t *Trivy) Config(
+	ctx context.Context,
+	// the path to directory to scan
+	// +required
+	dir *dagger.Directory,
+	// the returned exit code when misconfigurations are detected (0)
+	// +optional
+	exitCode int,
+	// the type of format to use when generating the compliance report (table)
+	// +optional
+	format string,
+	// a Rego policy for programmatically suppressing findings, offering more control
+	// than an ignore file, https://aquasecurity.github.io/trivy/latest/docs/configuration/filtering/#by-rego
+	// +optional
+	ignorePolicy *dagger.File,
+	// the maximum number of findings tolerated before failing, counting only those matching
+	// the selected severities. Useful for allowing known issues during a remediation window.
+	// Disabled by default
+	// +optional
+	maxFindings int,
+	// a list of custom policies for detecting misconfigurations. Rego files (.rego)
+	// are loaded as policies, all other files are loaded as data for those policies
+	// +optional
+	policy []*dagger.File,
+	// the severity of security issues to detect (UNKNOWN,LOW,MEDIUM,HIGH,CRITICAL)
+	// +optional
+	severity string,
+	// a custom go template to use when generating the compliance report
+	// +optional
+	template string,
+	// the maximum duration of the scan (e.g. 20m)
+	// +optional
+	timeout string,
+) (string, error) {
+	if err := validateTimeout(timeout); err != nil {
+		return "", err
+	}
+
+	cmd := []string{"config", "."}
+
+	ctr, ignorePolicyPath := withIgnorePolicy(t.Base, ignorePolicy)
+
+	sargs := scanArgs{
+		ExitCode:     exitCode,
+		Format:       format,
+		IgnoreFile:   t.IgnoreFile,
+		IgnorePolicy: ignorePolicyPath,
+		Severity:     severity,
+		Template:     template,
+		Timeout:      timeout,
+	}
+
+	ctr, policyArgs, err := withPolicies(ctx, ctr, policy)
+	if err != nil {
+		return "", err
+	}
+	cmd = append(cmd, policyArgs...)
+
+	return scan(ctx, ctr.WithDirectory(TrivyWorkDir, dir), cmd, sargs, maxFindings)
+}
+
+// Scan a single Dockerfile for misconfigurations that go against best practices, such as
+// using ADD instead of COPY, or not switching to a non-root USER. The Dockerfile is scanned
+// regardless of its name, with each finding reported alongside its severity
+//
+// Examples:
+//
+// # Scan a Dockerfile
+// $ trivy dockerfile --file Dockerfile
+//
+// # Fail a pull request if any high or critical misconfigurations are detected
+// $ trivy dockerfile --severity HIGH,CRITICAL --exit-code 1 --file build/app.Dockerfile
+func (t *Trivy) Dockerfile(
+	ctx context.Context,
+	// the returned exit code when misconfigurations are detected (0)
+	// +optional
+	exitCode int,
+	// the path to the Dockerfile to scan
+	// +required
+	file *dagger.File,
+	// the type of format to use when generating the compliance report (table)
+	// +optional
+	format string,
+	// a Rego policy for programmatically suppressing findings, offering more control
+	// than an ignore file, https://aquasecurity.github.io/trivy/latest/docs/configuration/filtering/#by-rego
+	// +optional
+	ignorePolicy *dagger.File,
+	// the maximum number of findings tolerated before failing, counting only those matching
+	// the selected severities. Useful for allowing known issues during a remediation window.
+	// Disabled by default
+	// +optional
+	maxFindings int,
+	// the severity of security issues to detect (UNKNOWN,LOW,MEDIUM,HIGH,CRITICAL)
+	// +optional
+	severity string,
+	// a custom go template to use when generating the compliance report
+	// +optional
+	template string,
+) (string, error) {
+	// Only files following the Dockerfile naming convention are detected by trivy
+	cmd := []string{"config", "--misconfig-scanners", "dockerfile", "."}
+
+	ctr, ignorePolicyPath := withIgnorePolicy(t.Base, ignorePolicy)
+
+	sargs := scanArgs{
+		ExitCode:     exitCode,
+		Format:       format,
+		IgnoreFile:   t.IgnoreFile,
+		IgnorePolicy: ignorePolicyPath,
+		Severity:     severity,
+		Template:     template,
+	}
+
+	return scan(ctx, ctr.WithFile("Dockerfile", file), cmd, sargs, maxFindings)
+}
+
+// Scan the workloads and resources of a live Kubernetes cluster for vulnerabilities,
+// misconfigurations and exposed secrets. A summary report is generated by default
+//
+// Examples:
+//
+// # Scan all workloads within a cluster
+// $ trivy k8s --report summary
+//
+// # Scan workloads within specific namespaces
+// $ trivy k8s --include-namespaces staging --report summary
+func (t *Trivy) Kubernetes(
+	ctx context.Context,
+	// a kubeconfig file for authenticating with the cluster
+	// +required
+	kubeconfig *dagger.Secret,
+	// generate a compliance report against a given standard (e.g. k8s-cis-1.23),
+	// https://aquasecurity.github.io/trivy/latest/docs/compliance/compliance/
+	// +optional
+	compliance string,
+	// the name of the kubeconfig context to scan, defaults to the current context
+	// +optional
+	kubeContext string,
+	// the returned exit code when vulnerabilities are detected (0)
+	// +optional
+	exitCode int,
+	// the type of format to use when generating the compliance report (table)
+	// +optional
+	format string,
+	// a Rego policy for programmatically suppressing findings, offering more control
+	// than an ignore file, https://aquasecurity.github.io/trivy/latest/docs/configuration/filtering/#by-rego
+	// +optional
+	ignorePolicy *dagger.File,
+	// filter out any vulnerabilities without a known fix
+	// +optional
+	ignoreUnfixed bool,
+	// the maximum number of findings tolerated before failing, counting only those matching
+	// the selected severities. As a cluster report cannot be converted into another format,
+	// it requires the json format. Disabled by default
+	// +optional
+	maxFindings int,
+	// a list of resource kinds to exclude from the scan, e.g. Secret
+	// +optional
+	excludeKinds []string,
+	// a list of namespaces to exclude from the scan
+	// +optional
+	excludeNamespaces []string,
+	// a list of resource kinds to scan, e.g. Deployment,StatefulSet
+	// +optional
+	includeKinds []string,
+	// a list of namespaces to scan, defaults to all namespaces
+	// +optional
+	includeNamespaces []string,
+	// the level of detail within the generated report (summary,all)
+	// +optional
+	// +default="summary"
+	report string,
+	// the types of scanner to execute (vuln,misconfig,secret,rbac)
+	// +optional
+	scanners string,
+	// a custom ruleset for detecting secrets, such as organization specific tokens, when
+	// the secret scanner is enabled, https://aquasecurity.github.io/trivy/latest/docs/scanner/secret/#configuration
+	// +optional
+	secretConfig *dagger.File,
+	// the severity of security issues to detect (UNKNOWN,LOW,MEDIUM,HIGH,CRITICAL)
+	// +optional
+	severity string,
+	// the maximum duration of the scan (e.g. 20m)
+	// +optional
+	timeout string,
+) (string, error) {
+	if err := validateTimeout(timeout); err != nil {
+		return "", err
+	}
+
+	switch report {
+	case "summary", "all":
+	default:
+		return "", fmt.Errorf("unsupported report '%s', expected one of (summary,all)", report)
+	}
+
+	if err := validateCompliance(compliance, report, maxFindings); err != nil {
+		return "", err
+	}
+
+	if maxFindings > 0 && format != "json" {
+		return "", fmt.Errorf("a maximum number of findings can only be used with the json format when scanning a cluster")
+	}
+
+	cmd := []string{"k8s", "--kubeconfig", TrivyKubeConfig, "--report", report}
+	if len(excludeKinds) > 0 {
+		cmd = append(cmd, "--exclude-kinds", strings.Join(excludeKinds, ","))
+	}
+
+	if len(excludeNamespaces) > 0 {
+		cmd = append(cmd, "--exclude-namespaces", strings.Join(excludeNamespaces, ","))
+	}
+
+	if len(includeKinds) > 0 {
+		cmd = append(cmd, "--include-kinds", strings.Join(includeKinds, ","))
+	}
+
+	if len(includeNamespaces) > 0 {
+		cmd = append(cmd, "--include-namespaces", strings.Join(includeNamespaces, ","))
+	}
+
+	if kubeContext != "" {
+		cmd = append(cmd, kubeContext)
+	}
+
+	ctr, ignorePolicyPath := withIgnorePolicy(t.Base.WithMountedSecret(TrivyKubeConfig, kubeconfig), ignorePolicy)
+	ctr, secretConfigPath := withSecretConfig(ctr, secretConfig)
+
+	sargs := scanArgs{
+		Compliance:    compliance,
+		ExitCode:      exitCode,
+		Format:        format,
+		IgnoreFile:    t.IgnoreFile,
+		IgnorePolicy:  ignorePolicyPath,
+		IgnoreUnfixed: ignoreUnfixed,
+		Scanners:      scanners,
+		SecretConfig:  secretConfigPath,
+		Severity:      severity,
+		Timeout:       timeout,
+	}
+
+	return scan(ctx, ctr, cmd, sargs, maxFindings)
+}
+
+// Convert an existing JSON report, generated by trivy, into a different format. Avoids
+// the need to rescan a target just to change the format of the report
+//
+// $ trivy image --format json --ref golang:1.21.7-bookworm > report.json
+//
+// Examples:
+//
+// # Convert a report into a table
+// $ trivy convert --report report.json
+//
+// # Convert a report into SARIF
+// $ trivy convert --format sarif --report report.json
+func (t *Trivy) Convert(
+	ctx context.Context,
+	// the type of format to convert the report into (table,sarif,cyclonedx)
+	// +optional
+	// +default="table"
+	format string,
+	// a JSON report previously generated by trivy
+	// +required
+	report *dagger.File,
+) (string, error) {
+	switch format {
+	case "table", "sarif", "cyclonedx":
+	default:
+		return "", fmt.Errorf("unsupported format '%s', expected one of (table,sarif,cyclonedx)", format)
+	}
 
 	return t.Base.
-		WithDirectory(TrivyWorkDir, dir).
-		WithExec(cmd).
+		WithMountedFile("report.json", report).
+		WithExec([]string{"convert", "--format", format, "report.json"}).
 		Stdout(ctx)
 }